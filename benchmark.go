@@ -0,0 +1,69 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"sync/atomic"
+)
+
+// Benchmark measures the time spent in parsing and filtering sessions.
+// The times are summed over all threads, so they can be longer than the whole run.
+type Benchmark struct {
+	start      time.Time
+	sessions   uint64
+	filtered   uint64
+	matched    uint64
+	parse_ns   uint64
+	filter_ns  uint64
+}
+
+func InitBenchmark() (*Benchmark) {
+	return &Benchmark {
+		start : time.Now(),
+	}
+}
+
+// AddParse records the time spent in parsing one session.
+func (b *Benchmark) AddParse(took time.Duration) {
+	atomic.AddUint64(&b.sessions, 1)
+	atomic.AddUint64(&b.parse_ns, uint64(took.Nanoseconds()))
+}
+
+// AddFilter records the time spent in filtering one session.
+func (b *Benchmark) AddFilter(took time.Duration, matched bool) {
+	atomic.AddUint64(&b.filtered, 1)
+	if matched { atomic.AddUint64(&b.matched, 1) }
+	atomic.AddUint64(&b.filter_ns, uint64(took.Nanoseconds()))
+}
+
+// Summary returns multiline text with the times spent in parsing and filtering.
+func (b *Benchmark) Summary() string {
+	sessions := atomic.LoadUint64(&b.sessions)
+	parse    := atomic.LoadUint64(&b.parse_ns)
+	filter   := atomic.LoadUint64(&b.filter_ns)
+
+	per_session := func(ns uint64) uint64 {
+		if sessions == 0 { return 0 }
+		return ns / sessions
+	}
+	percent := func(ns uint64) float64 {
+		if parse+filter == 0 { return 0 }
+		return float64(ns) * 100 / float64(parse+filter)
+	}
+
+	var s strings.Builder
+	took := time.Now().Sub(b.start).Seconds()
+	if atomic.LoadUint64(&b.filtered) == 0 {
+		s.WriteString(fmt.Sprintf("Benchmark: %d sessions (no filter) in %.3f s\n", sessions, took))
+	} else {
+		s.WriteString(fmt.Sprintf("Benchmark: %d sessions (%d matching filter) in %.3f s\n", sessions, atomic.LoadUint64(&b.matched), took))
+	}
+	s.WriteString(fmt.Sprintf("%-10s %12s %8s %14s\n", "phase", "time [s]", "share", "per session"))
+	s.WriteString(fmt.Sprintf("%-10s %12.3f %7.1f%% %11d ns\n", "parsing", float64(parse)/1e9, percent(parse), per_session(parse)))
+	s.WriteString(fmt.Sprintf("%-10s %12.3f %7.1f%% %11d ns\n", "filtering", float64(filter)/1e9, percent(filter), per_session(filter)))
+	return s.String()
+}
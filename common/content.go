@@ -0,0 +1,128 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package common
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sort"
+	"bytes"
+	"bufio"
+	"io/ioutil"
+	"regexp"
+	"compress/gzip"
+	"compress/bzip2"
+	"github.com/ulikunitz/xz"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression formats recognized by their magic bytes
+const (
+	COMPRESSION_NONE   = "none"
+	COMPRESSION_GZIP   = "gzip"
+	COMPRESSION_BZIP2  = "bzip2"
+	COMPRESSION_XZ     = "xz"
+	COMPRESSION_ZSTD   = "zstd"
+)
+
+var compression_magics = []struct {
+	name   string
+	magic  []byte
+}{
+	{ COMPRESSION_GZIP,  []byte{ 0x1f, 0x8b } },
+	{ COMPRESSION_BZIP2, []byte("BZh") },
+	{ COMPRESSION_XZ,    []byte{ 0xfd, '7', 'z', 'X', 'Z', 0x00 } },
+	{ COMPRESSION_ZSTD,  []byte{ 0x28, 0xb5, 0x2f, 0xfd } },
+}
+
+// DetectCompression returns the compression format based on the first bytes of the data
+// or COMPRESSION_NONE if it is not recognized.
+func DetectCompression(data []byte) string {
+	for _, c := range compression_magics {
+		if bytes.HasPrefix(data, c.magic) { return c.name }
+	}
+	return COMPRESSION_NONE
+}
+
+// Decompress detects the compression of the data read from `reader` and returns
+// the reader providing the decompressed data together with the detected compression
+// format. Data that is not compressed is returned unchanged. The returned reader must
+// be closed after reading (that does not close the original `reader`), because
+// some decompressors run their own goroutines.
+func Decompress(reader io.Reader) (io.ReadCloser, string, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(6)
+
+	compression := DetectCompression(magic)
+	var err error
+	var r   io.ReadCloser
+
+	if compression == COMPRESSION_GZIP {
+		r, err = gzip.NewReader(buffered)
+	} else if compression == COMPRESSION_BZIP2 {
+		r = ioutil.NopCloser(bzip2.NewReader(buffered))
+	} else if compression == COMPRESSION_XZ {
+		var x *xz.Reader
+		x, err = xz.NewReader(buffered)
+		if err == nil { r = ioutil.NopCloser(x) }
+	} else if compression == COMPRESSION_ZSTD {
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(buffered)
+		if err == nil { r = d.IOReadCloser() }
+	} else {
+		r = ioutil.NopCloser(buffered)
+	}
+
+	if err != nil { return nil, compression, fmt.Errorf("cannot decompress %s stream: %s", compression, err) }
+	return r, compression, nil
+}
+
+// CloseInput closes the reader returned by the input provider if it can be closed,
+// the standard input is never closed.
+func CloseInput(reader io.Reader) error {
+	if reader == io.Reader(os.Stdin) { return nil }
+	if c, ok := reader.(io.Closer); ok { return c.Close() }
+	return nil
+}
+
+// command outputs recognized in the text data
+const (
+	CONTENT_SESSION_LIST        = "session list"        // diagnose sys session list (and npu-session, mcast-session)
+	CONTENT_SESSION6_LIST       = "session6 list"       // diagnose sys session6 list
+	CONTENT_SHORT_SESSION_LIST  = "short session list"  // get system session list
+	CONTENT_VD_LIST             = "vd list"             // diagnose sys vd list
+	CONTENT_INTERFACE_LIST      = "interface list"      // diagnose netlink interface list
+)
+
+var content_patterns = []struct {
+	name  string
+	re    *regexp.Regexp
+}{
+	{ CONTENT_SESSION_LIST,       regexp.MustCompile("(?m)^(npu-)?session info:") },
+	{ CONTENT_SESSION6_LIST,      regexp.MustCompile("(?m)^session6 info:") },
+	{ CONTENT_SHORT_SESSION_LIST, regexp.MustCompile("(?m)^PROTO\\s+EXPIRE\\s+SOURCE\\s+SOURCE-NAT\\s+DESTINATION\\s+DESTINATION-NAT") },
+	{ CONTENT_VD_LIST,            regexp.MustCompile("(?m)^list virtual firewall info:") },
+	{ CONTENT_INTERFACE_LIST,     regexp.MustCompile("(?m)^if=\\S+\\s+family=") },
+}
+
+// DetectContent returns the types of the command outputs found in the (uncompressed) data,
+// in the order of their first appearance.
+func DetectContent(data []byte) []string {
+	type found struct {
+		name   string
+		offset int
+	}
+
+	all := make([]found, 0)
+	for _, c := range content_patterns {
+		loc := c.re.FindIndex(data)
+		if loc != nil { all = append(all, found{ c.name, loc[0] }) }
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].offset < all[j].offset })
+
+	names := make([]string, len(all))
+	for i, f := range all { names[i] = f.name }
+	return names
+}
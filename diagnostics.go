@@ -0,0 +1,71 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"foset/fortisession"
+)
+
+// ParseDiagnostics counts the sessions that had unparsable or missing fields
+// in strict mode. Each session is counted only once for each field group.
+type ParseDiagnostics struct {
+	lock       sync.Mutex
+	total      uint64
+	failed     uint64
+	unparsable map[string]uint64
+	missing    map[string]uint64
+}
+
+func InitParseDiagnostics() (*ParseDiagnostics) {
+	return &ParseDiagnostics {
+		unparsable : make(map[string]uint64),
+		missing    : make(map[string]uint64),
+	}
+}
+
+// Add records the parse errors of one session (empty list for correctly parsed session).
+func (pd *ParseDiagnostics) Add(errs []fortisession.ParseError) {
+	unparsable := make(map[string]bool)
+	missing    := make(map[string]bool)
+
+	for _, e := range errs {
+		if e.Missing { missing[e.Group] = true } else { unparsable[e.Group] = true }
+	}
+
+	pd.lock.Lock()
+	defer pd.lock.Unlock()
+
+	pd.total += 1
+	if len(errs) > 0 { pd.failed += 1 }
+	for group, _ := range unparsable { pd.unparsable[group] += 1 }
+	for group, _ := range missing    { pd.missing[group]    += 1 }
+}
+
+// Summary returns multiline text with the number of problematic sessions per field group.
+func (pd *ParseDiagnostics) Summary() string {
+	pd.lock.Lock()
+	defer pd.lock.Unlock()
+
+	groups := make([]string, 0)
+	for group, _ := range pd.unparsable { groups = append(groups, group) }
+	for group, _ := range pd.missing {
+		if _, exists := pd.unparsable[group]; !exists { groups = append(groups, group) }
+	}
+	sort.Strings(groups)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Parse diagnostics: %d of %d sessions had parse errors\n", pd.failed, pd.total))
+	if len(groups) == 0 { return b.String() }
+
+	b.WriteString(fmt.Sprintf("%-12s %12s %12s\n", "field group", "unparsable", "missing"))
+	for _, group := range groups {
+		b.WriteString(fmt.Sprintf("%-12s %12d %12d\n", group, pd.unparsable[group], pd.missing[group]))
+	}
+
+	return b.String()
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"fmt"
 	"bufio"
 	"os"
 	"foset/plugins/common"
@@ -12,11 +13,19 @@ import (
 	"foset/fortisession/forticonditioner"
 )
 
+// supported output formats
+const (
+	OUTPUT_TEXT     = "text"      // format string from "--output"
+	OUTPUT_JSON     = "json"      // one JSON array of all sessions
+	OUTPUT_NDJSON   = "ndjson"    // one JSON object per line
+)
+
 type ExecuteParams struct {
 	threads       int
-	sessionfile   string
+	sessionfiles  []string
 	nobuffer      bool
 	gzip_in       bool
+	input_format  string
 	cache_save    bool
 	cache_read    bool
 	data_request  *fortisession.SessionDataRequest
@@ -25,31 +34,49 @@ type ExecuteParams struct {
 	plugins       []*plugin_common.FosetPlugin
 	outfile       string
 	progfile      string
+	strict        bool
+	snapshot      uint32
+	resume        OpenSessionInputs
+	normalize     bool
+	benchmark     bool
+	explain       string
 }
 
-func execute(ep ExecuteParams) {
+// execute runs one cycle and returns the highest snapshot index seen in the input
+func execute(ep ExecuteParams) (uint32) {
 	parsed_sessions        := make(chan *fortisession.Session, 250*(ep.threads))
 	all_sessions_collected := make(chan bool)
 
 	var session_cache *CacheFile
 	var inerr error
+	var snapshots uint32
+
+	// diagnostics are only collected in strict mode
+	var diagnostics *ParseDiagnostics
+	if ep.strict { diagnostics = InitParseDiagnostics() }
+
+	// parsing and filtering times are only measured in benchmark mode
+	var benchmark *Benchmark
+	if ep.benchmark { benchmark = InitBenchmark() }
+
+	// explain specification is already verified in main
+	var explainer *Explainer
+	if len(ep.explain) > 0 && ep.conditioner != nil { explainer, _ = InitExplainer(ep.explain) }
 
 	if ep.cache_save {
-		session_cache, inerr = CacheInit(ep.sessionfile+ ".cache", "w", ep.threads)
+		session_cache, inerr = CacheInit(ep.sessionfiles[0]+ ".cache", "w", ep.threads)
 		ep.data_request.Plain = false
 		go save_sessions(parsed_sessions, session_cache, ep.conditioner, ep.plugins, all_sessions_collected)
-		file_processing := Init_file_processing(parsed_sessions, ep.data_request, ep.threads, ep.conditioner, ep.plugins, ep.progfile)
-		inerr = file_processing.Read_all_from_file(ep.sessionfile, Compression { Gzip : ep.gzip_in })
+		snapshots, inerr = read_files(ep, parsed_sessions, diagnostics, benchmark, explainer)
 
 	} else if ep.cache_read {
-		session_cache, inerr = CacheInit(ep.sessionfile+ ".cache", "r", ep.threads)
+		session_cache, inerr = CacheInit(ep.sessionfiles[0]+ ".cache", "r", ep.threads)
 		go collect_sessions(parsed_sessions, ep.formatter, ep.conditioner, ep.plugins, all_sessions_collected, ep.outfile, !(ep.nobuffer))
 		inerr = session_cache.ReadAll(parsed_sessions)
 
 	} else {
 		go collect_sessions(parsed_sessions, ep.formatter, ep.conditioner, ep.plugins, all_sessions_collected, ep.outfile, !(ep.nobuffer))
-		file_processing := Init_file_processing(parsed_sessions, ep.data_request, ep.threads, ep.conditioner, ep.plugins, ep.progfile)
-		inerr = file_processing.Read_all_from_file(ep.sessionfile, Compression { Gzip : ep.gzip_in })
+		snapshots, inerr = read_files(ep, parsed_sessions, diagnostics, benchmark, explainer)
 	}
 
 	if inerr != nil {
@@ -60,8 +87,32 @@ func execute(ep ExecuteParams) {
 	close(parsed_sessions)
 	<-all_sessions_collected // wait for all sessions to be collected in gorutine before exiting main program
 	if session_cache != nil { session_cache.Finalize() }
+
+	// cached sessions are not parsed again, so there is nothing to show for them
+	if diagnostics != nil && !ep.cache_read {
+		fmt.Fprint(os.Stderr, diagnostics.Summary())
+	}
+	if benchmark != nil && !ep.cache_read {
+		fmt.Fprint(os.Stderr, benchmark.Summary())
+	}
+
+	return snapshots
 }
 
+// read_files reads all the input files one by one, the sessions from all of them
+// are processed together
+func read_files(ep ExecuteParams, parsed_sessions chan *fortisession.Session, diagnostics *ParseDiagnostics, benchmark *Benchmark, explainer *Explainer) (uint32, error) {
+	file_processing := Init_file_processing(parsed_sessions, ep.data_request, ep.threads, ep.conditioner, ep.plugins, ep.progfile, diagnostics, benchmark, explainer, ep.snapshot, ep.resume, ep.normalize)
+
+	for _, sessionfile := range ep.sessionfiles {
+		log.Debugf("Reading sessions from \"%s\"", sessionfile)
+		err := file_processing.Read_all_from_file(sessionfile, Compression { Gzip : ep.gzip_in }, ep.input_format)
+		if err != nil { return 0, fmt.Errorf("%s: %s", sessionfile, err) }
+	}
+
+	file_processing.Finish()
+	return file_processing.snapshots, nil
+}
 
 func save_sessions(results chan *fortisession.Session, cache *CacheFile, conditioner *forticonditioner.Condition, plugins []*plugin_common.FosetPlugin, done chan bool) {
 	for session := range results {
@@ -85,17 +136,31 @@ func collect_sessions(results chan *fortisession.Session, formatter *fortiformat
 	// prepare the buffer (even if it is not going to be used)
 	w := bufio.NewWriterSize(printer, 1024)
 
-	//
+	write := func(line string) {
+		if buffer && !wparams.IsTerminal {
+			w.WriteString(line + "\n")
+		} else {
+			printer.Write(append([]byte(line), []byte("\n")...))
+		}
+	}
+
+	// JSON array needs to be opened and closed even if there are no sessions
+	if header := formatter.Header(); len(header) > 0 { write(header) }
+
+	first := true
 	for session := range results {
 		log.Tracef("Collecting session: %#x\n%#f", session.Serial, session)
 
-		if buffer && !wparams.IsTerminal {
-			w.WriteString(formatter.Format(session) + "\n")
+		if first {
+			write(formatter.Format(session))
+			first = false
 		} else {
-			printer.Write(append([]byte(formatter.Format(session)), []byte("\n")...))
+			write(formatter.Separator() + formatter.Format(session))
 		}
 	}
 
+	if footer := formatter.Footer(); len(footer) > 0 { write(footer) }
+
 	w.Flush()
 	run_plugins(plugins, PLUGINS_FINISHED, nil)
 	done <- true
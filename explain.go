@@ -0,0 +1,72 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"strconv"
+	"sync/atomic"
+	"foset/fortisession"
+	"foset/fortisession/forticonditioner"
+)
+
+// Explainer selects the sessions for which the evaluation of the filter is shown,
+// it is either the number of first sessions or the list of session serial numbers.
+type Explainer struct {
+	limit    uint64
+	serials  map[uint64]bool
+	shown    uint64
+}
+
+// InitExplainer parses the number of sessions (like "10") or the list of serial numbers
+// in hex (like "serial=68fb0e9e,68ffa62f").
+func InitExplainer(spec string) (*Explainer, error) {
+	x := &Explainer{}
+
+	if strings.HasPrefix(spec, "serial=") {
+		x.serials = make(map[uint64]bool)
+		for _, s := range strings.Split(spec[7:], ",") {
+			serial, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(s), "0x"), 16, 64)
+			if err != nil { return nil, fmt.Errorf("invalid session serial \"%s\"", s) }
+			x.serials[serial] = true
+		}
+		return x, nil
+	}
+
+	limit, err := strconv.ParseUint(spec, 10, 64)
+	if err != nil || limit == 0 {
+		return nil, fmt.Errorf("expected number of sessions or \"serial=\" followed by serial numbers, not \"%s\"", spec)
+	}
+	x.limit = limit
+	return x, nil
+}
+
+// Selected returns true if the session should be explained, with the number of sessions
+// the first sessions processed by any thread are selected
+func (x *Explainer) Selected(session *fortisession.Session) bool {
+	if x.serials != nil { return x.serials[session.Serial] }
+	if atomic.LoadUint64(&x.shown) >= x.limit { return false }
+	return atomic.AddUint64(&x.shown, 1) <= x.limit
+}
+
+// Show writes the evaluation of the filter for the session to stderr.
+func (x *Explainer) Show(session *fortisession.Session, conditioner *forticonditioner.Condition) {
+	matches, text := conditioner.Explain(session)
+
+	result := "does not match"
+	if matches { result = "matches" }
+
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Session %08x", session.Serial))
+	if len(session.Source) > 0 { s.WriteString(fmt.Sprintf(" from %s", session.Source)) }
+	if session.Snapshot != nil && session.Snapshot.Index > 0 { s.WriteString(fmt.Sprintf(" (snapshot %d)", session.Snapshot.Index)) }
+	s.WriteString(fmt.Sprintf(" %s the filter:\n", result))
+	s.WriteString(text)
+	s.WriteString("\n")
+
+	// one write, so the explanations from more threads are not mixed
+	os.Stderr.WriteString(s.String())
+}
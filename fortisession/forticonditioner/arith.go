@@ -0,0 +1,351 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+)
+
+// arithDim is the unit of the arithmetic value, only values with the same unit
+// can be added or compared (plain numbers can be used with any unit)
+type arithDim int
+const (
+	ad_plain    arithDim = iota
+	ad_bytes
+	ad_packets
+	ad_seconds
+	ad_rate      // bytes per second
+)
+
+func (d arithDim) String() string {
+	if d == ad_bytes   { return "bytes" }
+	if d == ad_packets { return "packets" }
+	if d == ad_seconds { return "seconds" }
+	if d == ad_rate    { return "rate" }
+	return "number"
+}
+
+// arithNode is one node of the parsed arithmetic expression,
+// leaf nodes are either fields or constants
+type arithNode struct {
+	op       byte  // '+', '-', '*', '/' or 0 for leaf
+	left     *arithNode
+	right    *arithNode
+	field    *fortifields.Field
+	is_field bool
+	value    float64
+	dim      arithDim
+}
+
+// arithCondition compares the results of two arithmetic expressions
+type arithCondition struct {
+	left      *arithNode
+	operator  string
+	right     *arithNode
+}
+
+var arith_operators    = []string{ "+", "-", "*", "/" }
+var arith_comparisons  = []string{ "=", "==", "eq", "is", "!=", "<>", "ne", "not", "<", "lt", "<=", "le", ">", "gt", ">=", "ge" }
+var arith_number       = regexp.MustCompile("^([0-9]+(?:\\.[0-9]+)?)([A-Za-z]*)$")
+
+// isArithmetic returns true if the expression contains arithmetic operator separated by spaces
+// and it starts with a number, parenthesis or a field that can be used in arithmetic expression
+func isArithmetic(expr string) bool {
+	words := strings.Fields(expr)
+	if len(words) < 3 { return false }
+
+	has_operator := false
+	for _, w := range words {
+		if inList(w, arith_operators) { has_operator = true }
+	}
+	if !has_operator { return false }
+
+	first := strings.TrimLeft(words[0], "(")
+	if arith_number.MatchString(first) { return true }
+	if p, exists := lookupField(first, &fortisession.SessionDataRequest{}); exists {
+		return fieldDim(p) != -1
+	}
+	return false
+}
+
+// parseArithmetic parses condition like "count[ob] + count[rb] > 1G"
+func parseArithmetic(expr string, request *fortisession.SessionDataRequest) (*arithCondition, error) {
+	tokens := tokenizeArithmetic(expr)
+
+	// comparison splits the left and the right side (with optional "!" negation)
+	split := -1
+	for i, t := range tokens {
+		if inList(strings.TrimPrefix(t, "!"), arith_comparisons) {
+			split = i
+			break
+		}
+	}
+	if split == -1 {
+		return nil, fmt.Errorf("arithmetic expression \"%s\" does not contain comparison", expr)
+	}
+
+	var cond arithCondition
+	var err error
+	cond.operator = tokens[split]
+
+	cond.left, err = parseArithmeticSide(tokens[:split], request)
+	if err == nil { cond.right, err = parseArithmeticSide(tokens[split+1:], request) }
+	if err == nil && cond.left.dim != ad_plain && cond.right.dim != ad_plain && cond.left.dim != cond.right.dim {
+		err = fmt.Errorf("cannot compare %s with %s", cond.left.dim, cond.right.dim)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("arithmetic expression \"%s\" error: %s", expr, err)
+	}
+
+	return &cond, nil
+}
+
+// tokenizeArithmetic splits the expression to fields, numbers, operators and parentheses
+func tokenizeArithmetic(expr string) []string {
+	tokens := make([]string, 0)
+	for _, word := range strings.Fields(expr) {
+		if inList(word, arith_operators) {
+			tokens = append(tokens, word)
+			continue
+		}
+
+		start := 0
+		for i, ch := range word {
+			if ch != '(' && ch != ')' { continue }
+			if i > start { tokens = append(tokens, word[start:i]) }
+			tokens = append(tokens, string(ch))
+			start = i+1
+		}
+		if start < len(word) { tokens = append(tokens, word[start:]) }
+	}
+	return tokens
+}
+
+// parseArithmeticSide parses one side of the comparison with the usual operator precedence
+func parseArithmeticSide(tokens []string, request *fortisession.SessionDataRequest) (*arithNode, error) {
+	if len(tokens) == 0 { return nil, fmt.Errorf("empty side of comparison") }
+
+	pos := 0
+	node, err := parseArithmeticSum(tokens, &pos, request)
+	if err != nil { return nil, err }
+	if pos < len(tokens) { return nil, fmt.Errorf("unexpected \"%s\"", tokens[pos]) }
+	return node, nil
+}
+
+func parseArithmeticSum(tokens []string, pos *int, request *fortisession.SessionDataRequest) (*arithNode, error) {
+	left, err := parseArithmeticProduct(tokens, pos, request)
+	if err != nil { return nil, err }
+
+	for *pos < len(tokens) && (tokens[*pos] == "+" || tokens[*pos] == "-") {
+		op := tokens[*pos][0]
+		*pos += 1
+		right, err := parseArithmeticProduct(tokens, pos, request)
+		if err != nil { return nil, err }
+		left, err = combineArithmetic(op, left, right)
+		if err != nil { return nil, err }
+	}
+	return left, nil
+}
+
+func parseArithmeticProduct(tokens []string, pos *int, request *fortisession.SessionDataRequest) (*arithNode, error) {
+	left, err := parseArithmeticOperand(tokens, pos, request)
+	if err != nil { return nil, err }
+
+	for *pos < len(tokens) && (tokens[*pos] == "*" || tokens[*pos] == "/") {
+		op := tokens[*pos][0]
+		*pos += 1
+		right, err := parseArithmeticOperand(tokens, pos, request)
+		if err != nil { return nil, err }
+		left, err = combineArithmetic(op, left, right)
+		if err != nil { return nil, err }
+	}
+	return left, nil
+}
+
+func parseArithmeticOperand(tokens []string, pos *int, request *fortisession.SessionDataRequest) (*arithNode, error) {
+	if *pos >= len(tokens) { return nil, fmt.Errorf("missing operand") }
+	token := tokens[*pos]
+	*pos += 1
+
+	if token == "(" {
+		node, err := parseArithmeticSum(tokens, pos, request)
+		if err != nil { return nil, err }
+		if *pos >= len(tokens) || tokens[*pos] != ")" { return nil, fmt.Errorf("missing \")\"") }
+		*pos += 1
+		return node, nil
+	}
+
+	if m := arith_number.FindStringSubmatch(token); m != nil {
+		value, _ := strconv.ParseFloat(m[1], 64)
+		multiplier, dim, err := parseArithmeticUnit(m[2])
+		if err != nil { return nil, err }
+		return &arithNode { value: value * multiplier, dim: dim }, nil
+	}
+
+	if p, exists := lookupField(token, request); exists {
+		dim := fieldDim(p)
+		if dim == -1 { return nil, fmt.Errorf("field \"%s\" cannot be used in arithmetic expression", token) }
+		return &arithNode { field: p, is_field: true, dim: dim }, nil
+	}
+
+	return nil, fmt.Errorf("unknown field or number \"%s\"", token)
+}
+
+// combineArithmetic creates new node and checks the units of the operands
+func combineArithmetic(op byte, left *arithNode, right *arithNode) (*arithNode, error) {
+	node := arithNode { op: op, left: left, right: right }
+
+	if op == '+' || op == '-' {
+		if left.dim != ad_plain && right.dim != ad_plain && left.dim != right.dim {
+			return nil, fmt.Errorf("cannot use \"%c\" with %s and %s", op, left.dim, right.dim)
+		}
+		node.dim = left.dim
+		if node.dim == ad_plain { node.dim = right.dim }
+
+	} else if op == '*' {
+		if left.dim != ad_plain && right.dim != ad_plain {
+			return nil, fmt.Errorf("cannot multiply %s by %s", left.dim, right.dim)
+		}
+		node.dim = left.dim
+		if node.dim == ad_plain { node.dim = right.dim }
+
+	} else if op == '/' {
+		if right.dim == ad_plain {
+			node.dim = left.dim
+		} else if left.dim == right.dim {
+			node.dim = ad_plain
+		} else if left.dim == ad_bytes && right.dim == ad_seconds {
+			node.dim = ad_rate
+		} else {
+			return nil, fmt.Errorf("cannot divide %s by %s", left.dim, right.dim)
+		}
+	}
+
+	return &node, nil
+}
+
+// parseArithmeticUnit returns the multiplier and the unit of the number suffix
+// - k, M, G, T (with optional "i" for binary and "B") for bytes
+// - s, m, h, d for time
+// - bps and Bps (with the byte prefixes) for rate
+func parseArithmeticUnit(unit string) (float64, arithDim, error) {
+	if unit == "" { return 1, ad_plain, nil }
+
+	if unit == "s" { return 1, ad_seconds, nil }
+	if unit == "m" { return 60, ad_seconds, nil }
+	if unit == "h" { return 3600, ad_seconds, nil }
+	if unit == "d" { return 86400, ad_seconds, nil }
+
+	dim := ad_bytes
+	var divide float64 = 1
+	if strings.HasSuffix(unit, "Bps") {
+		dim  = ad_rate
+		unit = strings.TrimSuffix(unit, "Bps")
+	} else if strings.HasSuffix(unit, "bps") {
+		dim    = ad_rate
+		divide = 8
+		unit   = strings.TrimSuffix(unit, "bps")
+	} else {
+		unit = strings.TrimSuffix(unit, "B")
+	}
+
+	var multiplier float64
+	switch unit {
+	case "":          multiplier = 1
+	case "k", "K":    multiplier = 1000
+	case "Ki":        multiplier = 1024
+	case "M":         multiplier = 1000*1000
+	case "Mi":        multiplier = 1024*1024
+	case "G":         multiplier = 1000*1000*1000
+	case "Gi":        multiplier = 1024*1024*1024
+	case "T":         multiplier = 1000*1000*1000*1000
+	case "Ti":        multiplier = 1024*1024*1024*1024
+	default:
+		return 0, ad_plain, fmt.Errorf("unknown unit \"%s\"", unit)
+	}
+
+	return multiplier / divide, dim, nil
+}
+
+// fieldDim returns the unit of the field value or -1 if the field cannot be used in arithmetic expression
+func fieldDim(f *fortifields.Field) arithDim {
+	if kind, single := kindOfField(f); kind != fk_number || !single { return -1 }
+
+	switch f.Unit {
+	case fortifields.UnitBytes:   return ad_bytes
+	case fortifields.UnitPackets: return ad_packets
+	case fortifields.UnitSeconds: return ad_seconds
+	case fortifields.UnitRate:    return ad_rate
+	}
+	return ad_plain
+}
+
+// evaluate returns the value of the expression for the session,
+// the second value is false if any field is not present in the session or on division by zero
+func (node *arithNode) evaluate(session *fortisession.Session) (float64, bool) {
+	if node.is_field {
+		v := getFieldValues(node.field, session)
+		if len(v.numbers) != 1 { return 0, false }
+		return float64(v.numbers[0]), true
+	} else if node.op == 0 {
+		return node.value, true
+	}
+
+	left, ok := node.left.evaluate(session)
+	if !ok { return 0, false }
+	right, ok := node.right.evaluate(session)
+	if !ok { return 0, false }
+
+	if node.op == '+' { return left + right, true }
+	if node.op == '-' { return left - right, true }
+	if node.op == '*' { return left * right, true }
+	if right == 0     { return 0, false }
+	return left / right, true
+}
+
+// matches evaluates both sides and compares them
+func (cond *arithCondition) matches(session *fortisession.Session) bool {
+	operator := cond.operator
+	reverse  := false
+	if strings.HasPrefix(operator, "!") {
+		reverse  = true
+		operator = operator[1:]
+	}
+
+	left, ok := cond.left.evaluate(session)
+	if !ok { return reverse }
+	right, ok := cond.right.evaluate(session)
+	if !ok { return reverse }
+
+	var result bool
+	if operator == "=" || operator == "==" || operator == "eq" || operator == "is" {
+		result = left == right
+	} else if operator == "!=" || operator == "<>" || operator == "ne" || operator == "not" {
+		result = left != right
+	} else if operator == "<" || operator == "lt" {
+		result = left < right
+	} else if operator == "<=" || operator == "le" {
+		result = left <= right
+	} else if operator == ">" || operator == "gt" {
+		result = left > right
+	} else if operator == ">=" || operator == "ge" {
+		result = left >= right
+	}
+
+	log.Tracef("Check session 0x%x: arithmetic %f \"%s\" %f -> %t\n", session.Serial, left, operator, right, result)
+	if reverse { return !result }
+	return result
+}
+
+func inList(s string, list []string) bool {
+	for _, l := range list {
+		if s == l { return true }
+	}
+	return false
+}
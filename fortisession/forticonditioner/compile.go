@@ -0,0 +1,489 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"strings"
+	"strconv"
+	"time"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+	"foset/fortisession/fortiformatter"
+)
+
+// matcher is the compiled condition, it returns true if the session matches it
+type matcher func(session *fortisession.Session) bool
+
+// stringField calls the test for the text value(s) of the session, the same way
+// as the appliers of the fields in the registry
+type stringField func(session *fortisession.Session, test func(string) bool) bool
+
+// compile converts the condition tree to one matcher function, so the field names
+// and operators are not evaluated again for each session and the values are parsed only once
+func (cond *Condition) compile(c conditionOrExpression) (matcher, error) {
+	if c.isExpression() {
+		return cond.compileExpression(c.(expression))
+	}
+
+	var subs []conditionOrExpression
+	if c.isAnd() { subs = c.(and).sub } else { subs = c.(or).sub }
+
+	matchers := make([]matcher, len(subs))
+	for i, sub := range subs {
+		var err error
+		matchers[i], err = cond.compile(sub)
+		if err != nil { return nil, err }
+	}
+
+	if c.isAnd() {
+		return func(session *fortisession.Session) bool {
+			for _, m := range matchers {
+				if !m(session) { return false }
+			}
+			return true
+		}, nil
+	}
+
+	return func(session *fortisession.Session) bool {
+		for _, m := range matchers {
+			if m(session) { return true }
+		}
+		return false
+	}, nil
+}
+
+func (cond *Condition) compileExpression(e expression) (matcher, error) {
+	var m matcher
+
+	if e.arith != nil {
+		m = e.arith.matches
+
+	} else if e.rfield_name != "" {
+		m = func(session *fortisession.Session) bool {
+			return cond.compareFields(e.lside, e.operator, e.rfield, e.rfield_name, session)
+		}
+
+	} else {
+		operator := strings.TrimPrefix(e.operator, "!")
+
+		var err error
+		m, err = cond.compileValue(e.lside, operator, e.rside, e.extra, e.formatter)
+		if err != nil { return nil, err }
+
+		if len(operator) != len(e.operator) {
+			positive := m
+			m = func(session *fortisession.Session) bool { return !positive(session) }
+		}
+
+		if log.IsTraceEnabled() {
+			traced := m
+			m = func(session *fortisession.Session) bool {
+				result := traced(session)
+				log.Tracef("Check session 0x%x: \"%s\" \"%s\" \"%s\" -> %t\n", session.Serial, e.lside.Name, e.operator, e.rside, result)
+				return result
+			}
+		}
+	}
+
+	if e.negative {
+		positive := m
+		m = func(session *fortisession.Session) bool { return !positive(session) }
+	}
+
+	return m, nil
+}
+
+// compileValue creates the matcher comparing the field with the value, when the value
+// is created by nested formatter, the comparison is created again for each session
+func (cond *Condition) compileValue(lside *fortifields.Field, operator string, rside string, extra string, formatter *fortiformatter.Formatter) (matcher, error) {
+	switch lside.Name {
+	case "custom":
+		return cond.compileCustom(lside, operator, rside, extra, formatter)
+
+	case "service":
+		if formatter != nil {
+			return func(session *fortisession.Session) bool {
+				test, err := compileService(operator, formatter.Format(session))
+				if err != nil { matchError(err) }
+				return serviceMatches(session, test)
+			}, nil
+		}
+		test, err := compileService(operator, rside)
+		if err != nil { return nil, err }
+		return func(session *fortisession.Session) bool { return serviceMatches(session, test) }, nil
+
+	case "started":
+		if formatter != nil {
+			return func(session *fortisession.Session) bool {
+				test, err := compileStarted(operator, formatter.Format(session))
+				if err != nil { matchError(err) }
+				return startedMatches(session, test)
+			}, nil
+		}
+		test, err := compileStarted(operator, rside)
+		if err != nil { return nil, err }
+		return func(session *fortisession.Session) bool { return startedMatches(session, test) }, nil
+
+	case "raw":
+		// raw fields are not present in all sessions
+		lfield := func(session *fortisession.Session, test func(string) bool) bool {
+			v, exists := session.Raw[extra]
+			return exists && test(v)
+		}
+		return cond.compileStringMatcher(lfield, operator, rside, formatter)
+	}
+
+	if lfield := lside.Number; lfield != nil {
+		if formatter != nil {
+			return func(session *fortisession.Session) bool {
+				test, err := compileNumber(lside, operator, formatter.Format(session))
+				if err != nil { matchError(err) }
+				return lfield(session, test)
+			}, nil
+		}
+		test, err := compileNumber(lside, operator, rside)
+		if err != nil { return nil, err }
+		return func(session *fortisession.Session) bool { return lfield(session, test) }, nil
+	}
+
+	if lfield := lside.IP; lfield != nil {
+		if formatter != nil {
+			return func(session *fortisession.Session) bool {
+				test, err := cond.compileIP(operator, formatter.Format(session))
+				if err != nil { matchError(err) }
+				return lfield(session, test)
+			}, nil
+		}
+		test, err := cond.compileIP(operator, rside)
+		if err != nil { return nil, err }
+		return func(session *fortisession.Session) bool { return lfield(session, test) }, nil
+	}
+
+	if lside.Text != nil {
+		return cond.compileStringMatcher(lside.Text, operator, rside, formatter)
+	}
+
+	return nil, fmt.Errorf("field \"%s\" cannot be compiled", lside.Name)
+}
+
+func (cond *Condition) compileStringMatcher(lfield stringField, operator string, rside string, formatter *fortiformatter.Formatter) (matcher, error) {
+	if formatter != nil {
+		return func(session *fortisession.Session) bool {
+			test, err := cond.compileString(operator, formatter.Format(session))
+			if err != nil { matchError(err) }
+			return lfield(session, test)
+		}, nil
+	}
+
+	test, err := cond.compileString(operator, rside)
+	if err != nil { return nil, err }
+	return func(session *fortisession.Session) bool { return lfield(session, test) }, nil
+}
+
+// compileCustom creates the matcher for the custom field, its type is only known when the session
+// is parsed, so both the number and string comparisons are prepared and the errors are reported
+// only when the wrong one is used
+func (cond *Condition) compileCustom(lside *fortifields.Field, operator string, rside string, extra string, formatter *fortiformatter.Formatter) (matcher, error) {
+	number, number_err := compileNumber(lside, operator, rside)
+	text, text_err     := cond.compileString(operator, rside)
+
+	return func(session *fortisession.Session) bool {
+		v, exists := session.Custom[extra]
+		if !exists {
+			log.Criticalf("Nonexisting custom variable \"%s\"", extra)
+			os.Exit(100)
+		}
+
+		number, number_err, text, text_err := number, number_err, text, text_err
+		if formatter != nil {
+			formatted := formatter.Format(session)
+			number, number_err = compileNumber(lside, operator, formatted)
+			text, text_err     = cond.compileString(operator, formatted)
+		}
+
+		if v.IsString() {
+			if text_err != nil { matchError(text_err) }
+			return text(v.GetString())
+		} else if v.IsUint64() {
+			if number_err != nil { matchError(number_err) }
+			return number(v.GetUint64())
+		} else if v.IsFloat64() {
+			log.Warningf("To compare float type custom variable, it is first converted to unsigned integer")
+			if number_err != nil { matchError(number_err) }
+			return number(uint64(v.GetUint64()))
+		} else if v.IsEmpty() {
+			return false
+		}
+
+		log.Criticalf("Unknown custom field type")
+		os.Exit(100)
+		return false
+	}, nil
+}
+
+// compileStarted returns the test for the time the session was started
+func compileStarted(operator string, rside string) (func(time.Time) bool, error) {
+	t, err := parseTime(rside)
+	if err != nil { return nil, err }
+
+	if operator == "before" || operator == "<" || operator == "lt" {
+		return func(started time.Time) bool { return started.Before(t) }, nil
+	} else if operator == "<=" || operator == "le" {
+		return func(started time.Time) bool { return !started.After(t) }, nil
+	} else if operator == "after" || operator == ">" || operator == "gt" {
+		return func(started time.Time) bool { return started.After(t) }, nil
+	} else if operator == ">=" || operator == "ge" {
+		return func(started time.Time) bool { return !started.Before(t) }, nil
+	}
+	return nil, fmt.Errorf("unknown time operator \"%s\", use \"before\" or \"after\"", operator)
+}
+
+// startedMatches computes the start time from the capture time of the session dump and
+// the session duration, it never matches when the capture time is not known
+func startedMatches(session *fortisession.Session, test func(time.Time) bool) bool {
+	if session.Snapshot == nil || session.Snapshot.Time.IsZero() { return false }
+	return test(session.Snapshot.Time.Add(-time.Duration(session.Basics.Duration) * time.Second))
+}
+
+func serviceMatches(session *fortisession.Session, test func(proto uint16, dport uint16) bool) bool {
+	_, _, _, dst_port, _, _, _ := session.GetPeers()
+	return test(session.Basics.Protocol, dst_port)
+}
+
+// matchError is used for the values that are only known when the session is matched
+func matchError(err error) {
+	log.Criticalf("Filter error: %s", err)
+	os.Exit(100)
+}
+
+// compileNumber parses the value the way the field expects it and returns the test
+// for the field value, empty value means that the field must not be zero
+func compileNumber(lside *fortifields.Field, operator string, rside string) (func(uint64) bool, error) {
+	if lside.Values == fortifields.ValuesRate {
+		right, err := parseRate(rside)
+		if err != nil { return nil, err }
+		return numberTest(operator, right)
+	}
+
+	if operator == "in" {
+		ranges, err := fieldNumberList(lside, rside)
+		if err != nil { return nil, err }
+		return func(v uint64) bool { return inRanges(v, ranges) }, nil
+	}
+
+	// empty status means established
+	if len(operator) == 0 && len(rside) == 0 && lside.Values != fortifields.ValuesState {
+		return func(v uint64) bool { return v > 0 }, nil
+	}
+
+	ranges, err := fieldNumber(lside, rside)
+	if err != nil { return nil, err }
+	if len(ranges) == 1 && ranges[0].low == ranges[0].high {
+		return numberTest(operator, ranges[0].low)
+	}
+
+	// service with more ports can be only compared for equality
+	if operator == "" || operator == "==" || operator == "=" || operator == "eq" || operator == "is" {
+		return func(v uint64) bool { return inRanges(v, ranges) }, nil
+	} else if operator == "<>" || operator == "ne" || operator == "not" || operator == "!=" {
+		return func(v uint64) bool { return !inRanges(v, ranges) }, nil
+	}
+	return nil, fmt.Errorf("operator \"%s\" cannot be used with \"%s\" which has more ports", operator, rside)
+}
+
+func numberTest(operator string, right uint64) (func(uint64) bool, error) {
+	if operator == "" || operator == "==" || operator == "=" || operator == "eq" || operator == "is" {
+		return func(v uint64) bool { return v == right }, nil
+	} else if operator == "<>" || operator == "ne" || operator == "not" || operator == "!=" {
+		return func(v uint64) bool { return v != right }, nil
+	} else if operator == ">" || operator == "gt" {
+		return func(v uint64) bool { return v > right }, nil
+	} else if operator == ">=" || operator == "ge" {
+		return func(v uint64) bool { return v >= right }, nil
+	} else if operator == "<" || operator == "lt" {
+		return func(v uint64) bool { return v < right }, nil
+	} else if operator == "<=" || operator == "le" {
+		return func(v uint64) bool { return v <= right }, nil
+	}
+
+	return nil, fmt.Errorf("unknown integer operator \"%s\"", operator)
+}
+
+// numberRange is the range of numbers including both ends
+type numberRange struct {
+	low   uint64
+	high  uint64
+}
+
+func inRanges(v uint64, ranges []numberRange) bool {
+	for _, r := range ranges {
+		if v >= r.low && v <= r.high { return true }
+	}
+	return false
+}
+
+// fieldNumber converts one value to number the way the field expects it. Besides the decimal
+// and hex numbers, the session state and protocol names, "internal" policy and service names
+// for ports are accepted. Service can have more ports, so the list of ranges is returned.
+func fieldNumber(lside *fortifields.Field, value string) ([]numberRange, error) {
+	value = strings.TrimSpace(value)
+
+	text := value
+	if lside.Values == fortifields.ValuesState {
+		// empty status means established
+		if len(value) == 0 { text = "1" }
+		// older versions accepted it with the number 11 that FortiOS never uses
+		if strings.HasPrefix(strings.ToLower(value), "closi") {
+			return nil, fmt.Errorf("session state \"%s\" does not exist in FortiOS (see FD30042), use \"close\" or \"close_wait\"", value)
+		}
+		if n, known := fortifields.StateNumber(value); known { return []numberRange{ { low: n, high: n } }, nil }
+	} else if lside.Values == fortifields.ValuesProto {
+		if n, known := fortifields.ProtoNumber(value); known { return []numberRange{ { low: n, high: n } }, nil }
+	} else if lside.Values == fortifields.ValuesPolicy && value == "internal" {
+		text = "4294967295"
+	} else if lside.Values == fortifields.ValuesTime {
+		n, err := parseSeconds(value)
+		if err != nil { return nil, err }
+		return []numberRange{ { low: n, high: n } }, nil
+	}
+
+	if n, err := parseTextNumber(text); err == nil {
+		return []numberRange{ { low: n, high: n } }, nil
+	}
+
+	// accept the same unit suffixes as the arithmetic expressions (like "count[ob] > 1G")
+	if m := arith_number.FindStringSubmatch(value); m != nil && lside.Unit != fortifields.UnitPlain {
+		multiplier, dim, err := parseArithmeticUnit(m[2])
+		if err != nil { return nil, err }
+		if field_dim := fieldDim(lside); dim != field_dim {
+			return nil, fmt.Errorf("cannot compare %s with %s", field_dim, dim)
+		}
+		f, _ := strconv.ParseFloat(m[1], 64)
+		n := uint64(f * multiplier)
+		return []numberRange{ { low: n, high: n } }, nil
+	}
+
+	if lside.Values == fortifields.ValuesPort && len(value) > 0 && !strings.ContainsAny(value[:1], "0123456789") {
+		entries, err := lookupService(value)
+		if err != nil { return nil, err }
+
+		// the same ports are often defined for more protocols
+		ranges := make([]numberRange, 0, len(entries))
+		for _, e := range entries {
+			r := numberRange{ low: uint64(e.low), high: uint64(e.high) }
+			known := false
+			for _, k := range ranges { known = known || k == r }
+			if !known { ranges = append(ranges, r) }
+		}
+		return ranges, nil
+	}
+
+	if lside.Values == fortifields.ValuesProto { return nil, fmt.Errorf("unknown protocol \"%s\"", value) }
+	return nil, fmt.Errorf("invalid number \"%s\"", value)
+}
+
+// fieldNumberList parses the comma separated list of values and ranges (like "80,443,8000-8100")
+func fieldNumberList(lside *fortifields.Field, rside string) ([]numberRange, error) {
+	ranges := make([]numberRange, 0)
+
+	for _, item := range strings.Split(rside, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 { return nil, fmt.Errorf("empty item in list \"%s\"", rside) }
+
+		// some names contain "-" as well (like "syn-sent"), so the whole item is tried first
+		r, err := fieldNumber(lside, item)
+		if err != nil && strings.Contains(item, "-") {
+			parts := strings.SplitN(item, "-", 2)
+			low, lerr  := fieldNumber(lside, parts[0])
+			high, herr := fieldNumber(lside, parts[1])
+			if lerr != nil { return nil, lerr }
+			if herr != nil { return nil, herr }
+			if len(low) != 1 || len(high) != 1 || low[0].high > high[0].low {
+				return nil, fmt.Errorf("invalid range \"%s\"", item)
+			}
+			r, err = []numberRange{ { low: low[0].low, high: high[0].high } }, nil
+		}
+		if err != nil { return nil, err }
+
+		ranges = append(ranges, r...)
+	}
+
+	return ranges, nil
+}
+
+// compileString returns the test for the string field value, empty value
+// means that the field must not be empty
+func (cond *Condition) compileString(operator string, rside string) (func(string) bool, error) {
+	if len(operator) == 0 && len(rside) == 0 {
+		return func(v string) bool { return len(v) > 0 }, nil
+	}
+
+	// regular expression must not be lowercased
+	if isRegexOperator(operator) {
+		re, err := cond.getRegexp(operator, rside)
+		if err != nil { return nil, err }
+		return re.MatchString, nil
+	}
+
+	var test func(string) bool
+	lower := false
+	if len(operator) > 0 && operator[0] == '#' {
+		lower    = true
+		rside    = strings.ToLower(rside)
+		operator = operator[1:]
+	}
+
+	if operator == "" || operator == "==" || operator == "=" || operator == "eq" || operator == "is" {
+		test = func(v string) bool { return v == rside }
+	} else if operator == "not" || operator == "!=" || operator == "<>" || operator == "ne" {
+		test = func(v string) bool { return v != rside }
+	} else if operator == "prefix" || operator == "starts" || operator == "start" {
+		test = func(v string) bool { return strings.HasPrefix(v, rside) }
+	} else if operator == "suffix" || operator == "ends" || operator == "end" {
+		test = func(v string) bool { return strings.HasSuffix(v, rside) }
+	} else if operator == "contains" || operator == "contain" || operator == "c" || operator == "has" {
+		test = func(v string) bool { return strings.Contains(v, rside) }
+	} else {
+		return nil, fmt.Errorf("unknown string operator \"%s\"", operator)
+	}
+
+	if lower {
+		return func(v string) bool { return test(strings.ToLower(v)) }, nil
+	}
+	return test, nil
+}
+
+// compileIP returns the test for the IP address field value
+func (cond *Condition) compileIP(operator string, rside string) (func(net.IP) bool, error) {
+	if operator == "" || operator == "=" || operator == "==" || operator == "is" ||
+	   operator == "!=" || operator == "<>" || operator == "not" {
+		ip := net.ParseIP(rside)
+		if ip == nil { return nil, fmt.Errorf("IP not parsable \"%s\"", rside) }
+
+		if operator == "!=" || operator == "<>" || operator == "not" {
+			return func(v net.IP) bool { return !ip.Equal(v) }, nil
+		}
+		return func(v net.IP) bool { return ip.Equal(v) }, nil
+
+	} else if operator == "in" && strings.HasPrefix(rside, "@") {
+		set, exists := cond.ipsets[rside[1:]]
+		if !exists {
+			// only the right side created by nested formatter is not loaded in Init
+			var err error
+			set, err = loadIPSet(rside[1:])
+			if err != nil { return nil, fmt.Errorf("cannot load IP set \"%s\": %s", rside[1:], err) }
+		}
+		return set.Contains, nil
+
+	} else if operator == "in" {
+		_, subnet, err := net.ParseCIDR(rside)
+		if err != nil { return nil, fmt.Errorf("IP CIDR not parsable \"%s\"", rside) }
+		return subnet.Contains, nil
+	}
+
+	return nil, fmt.Errorf("unknown ip operator \"%s\"", operator)
+}
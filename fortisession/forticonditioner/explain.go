@@ -0,0 +1,172 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"strings"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+)
+
+// explainNode is the condition tree where each node has its own matcher,
+// so the result of every part of the filter can be shown
+type explainNode struct {
+	sub      []*explainNode
+	is_and   bool
+	e        expression  // only for expression
+	matcher  matcher     // only for expression
+}
+
+// compileExplain compiles each expression separately, the and/or nodes are evaluated by Explain
+func (cond *Condition) compileExplain(c conditionOrExpression) (*explainNode, error) {
+	if c.isExpression() {
+		m, err := cond.compileExpression(c.(expression))
+		if err != nil { return nil, err }
+		return &explainNode{ e: c.(expression), matcher: m }, nil
+	}
+
+	var subs []conditionOrExpression
+	if c.isAnd() { subs = c.(and).sub } else { subs = c.(or).sub }
+
+	node := &explainNode{ is_and: c.isAnd() }
+	for _, sub := range subs {
+		n, err := cond.compileExplain(sub)
+		if err != nil { return nil, err }
+		node.sub = append(node.sub, n)
+	}
+	return node, nil
+}
+
+// Explain evaluates all parts of the filter for the session and returns the result together with
+// the text describing the result of each part and the session values it compared. Unlike Matches,
+// the rest of "and" or "or" is evaluated even when the result is already known.
+func (cond *Condition) Explain(session *fortisession.Session) (bool, string) {
+	cond.explain_once.Do(func() {
+		cond.explain, cond.explain_err = cond.compileExplain(cond.sub)
+	})
+	if cond.explain_err != nil { return false, fmt.Sprintf("cannot explain the filter: %s\n", cond.explain_err) }
+
+	var s strings.Builder
+	result := cond.explain.evaluate(session, 0, &s)
+	return result, s.String()
+}
+
+func (node *explainNode) evaluate(session *fortisession.Session, indent int, s *strings.Builder) bool {
+	prefix := strings.Repeat("  ", indent)
+
+	if node.matcher != nil {
+		result := node.matcher(session)
+		text := fmt.Sprintf("%s%s %s", prefix, explainResult(result), node.e.text)
+		s.WriteString(fmt.Sprintf("%-60s  %s\n", text, explainValues(node.e, session)))
+		return result
+	}
+
+	// the result of and/or is only known after the sub-conditions, so they are written later
+	var sub strings.Builder
+	result := node.is_and
+	for _, n := range node.sub {
+		r := n.evaluate(session, indent+1, &sub)
+		if node.is_and { result = result && r } else { result = result || r }
+	}
+
+	if node.is_and {
+		s.WriteString(fmt.Sprintf("%s%s AND\n", prefix, explainResult(result)))
+	} else {
+		s.WriteString(fmt.Sprintf("%s%s OR\n", prefix, explainResult(result)))
+	}
+	s.WriteString(sub.String())
+	return result
+}
+
+func explainResult(result bool) string {
+	if result { return "[true ]" }
+	return "[false]"
+}
+
+// explainValues returns the session values the expression compares
+func explainValues(e expression, session *fortisession.Session) string {
+	var values []string
+
+	if e.arith != nil {
+		for _, side := range []*arithNode{ e.arith.left, e.arith.right } {
+			if v, ok := side.evaluate(session); ok {
+				values = append(values, fmt.Sprintf("%g", v))
+			} else {
+				values = append(values, "n/a")
+			}
+		}
+		return "left = " + values[0] + ", right = " + values[1]
+	}
+
+	var v string
+	switch e.lside.Name {
+	case "custom":
+		mv, exists := session.Custom[e.extra]
+		if !exists || mv.IsEmpty() {
+			v = "custom " + e.extra + " = (missing)"
+		} else if mv.IsString() {
+			v = fmt.Sprintf("custom %s = \"%s\"", e.extra, mv.GetString())
+		} else {
+			v = fmt.Sprintf("custom %s = %d", e.extra, mv.GetUint64())
+		}
+	case "raw":
+		if rv, exists := session.Raw[e.extra]; exists {
+			v = fmt.Sprintf("raw %s = \"%s\"", e.extra, rv)
+		} else {
+			v = "raw " + e.extra + " = (missing)"
+		}
+	case "service":
+		_, _, _, dst_port, _, _, _ := session.GetPeers()
+		v = fmt.Sprintf("proto = %d, dport = %d", session.Basics.Protocol, dst_port)
+	case "started":
+		if session.Snapshot == nil || session.Snapshot.Time.IsZero() {
+			v = "started = (capture time unknown)"
+		} else {
+			started := session.Snapshot.Time.Add(-time.Duration(session.Basics.Duration) * time.Second)
+			v = "started = " + started.Format("2006-01-02 15:04:05")
+		}
+	default:
+		v = e.lside.Name + " = " + collectValues(e.lside, session)
+		if e.lside.Values == fortifields.ValuesRate { v += " (Bps)" }
+	}
+	values = append(values, v)
+
+	if e.rfield_name != "" {
+		values = append(values, e.rfield_name + " = " + collectValues(e.rfield, session))
+	} else if e.formatter != nil {
+		values = append(values, fmt.Sprintf("value = \"%s\"", e.formatter.Format(session)))
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// collectValues returns all values of the field the matcher would test, fields where all values
+// must match (like "npuflag") stop on the first failed test, so the test is done twice
+func collectValues(p *fortifields.Field, session *fortisession.Session) string {
+	var values []string
+
+	if f := p.Number; f != nil {
+		for _, pass := range []bool{ false, true } {
+			var found []string
+			f(session, func(v uint64) bool { found = append(found, fmt.Sprintf("%d", v)); return pass })
+			if len(found) > len(values) { values = found }
+		}
+	} else if f := p.IP; f != nil {
+		f(session, func(v net.IP) bool { values = append(values, v.String()); return false })
+	} else if f := p.Text; f != nil {
+		f(session, func(v string) bool { values = append(values, "\"" + v + "\""); return false })
+	}
+
+	if len(values) == 0 { return "(missing)" }
+	return strings.Join(values, " ")
+}
+
+// fieldName returns the name of the field, arithmetic expressions have no field
+func fieldName(f *fortifields.Field) string {
+	if f == nil { return "-" }
+	return f.Name
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"os"
+	"net"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+)
+
+// fieldKind is the type of the field value used when two fields are compared with each other
+type fieldKind int
+const (
+	fk_none    fieldKind = iota  // field cannot be compared with another field
+	fk_ip
+	fk_number
+	fk_string
+)
+
+func (k fieldKind) String() string {
+	if k == fk_ip     { return "ip" }
+	if k == fk_number { return "number" }
+	if k == fk_string { return "string" }
+	return "none"
+}
+
+// fieldValues holds all the values of one field in the session,
+// fields like "host" have more values (source, destination and NAT address)
+type fieldValues struct {
+	ips      []net.IP
+	numbers  []uint64
+	strings  []string
+}
+
+// kindOfField returns the type of values the field has and whether
+// the field has only one value (only such field can be on the right side),
+// fields where all values must match cannot be compared with another field
+func kindOfField(f *fortifields.Field) (kind fieldKind, single bool) {
+	if f.All { return fk_none, false }
+
+	switch f.Kind {
+	case fortifields.KindIP:     return fk_ip, !f.Multi
+	case fortifields.KindNumber: return fk_number, !f.Multi
+	case fortifields.KindString: return fk_string, !f.Multi
+	}
+	return fk_none, false
+}
+
+// getFieldValues returns the values of the field from the session,
+// the field must be one of those with known kind
+func getFieldValues(f *fortifields.Field, session *fortisession.Session) (v fieldValues) {
+	if f.Number != nil {
+		f.Number(session, func(n uint64) bool { v.numbers = append(v.numbers, n); return false })
+	} else if f.IP != nil {
+		f.IP(session, func(ip net.IP) bool { v.ips = append(v.ips, ip); return false })
+	} else if f.Text != nil {
+		f.Text(session, func(s string) bool { v.strings = append(v.strings, s); return false })
+	}
+	return v
+}
+
+// compareFields compares the field on the left side with the field on the right side of the expression.
+// If the left side field has more values, it is enough when one of them matches.
+// When the value of any side is not present in the session, it never matches.
+func (c *Condition) compareFields(lside *fortifields.Field, operator string, rside *fortifields.Field, rname string, session *fortisession.Session) bool {
+	var reverse bool
+	if len(operator) > 0 && operator[0] == '!' {
+		reverse   = true
+		operator  = operator[1:]
+	}
+
+	left  := getFieldValues(lside, session)
+	right := getFieldValues(rside, session)
+
+	result := false
+	kind, _ := kindOfField(lside)
+
+	if kind == fk_ip && len(right.ips) == 1 && right.ips[0] != nil {
+		for _, ip := range left.ips {
+			if ip == nil { continue }
+			if operator == "" || operator == "=" || operator == "==" || operator == "is" {
+				result = ip.Equal(right.ips[0])
+			} else if operator == "!=" || operator == "<>" || operator == "not" {
+				result = !ip.Equal(right.ips[0])
+			} else {
+				log.Criticalf("Check field \"%s\" error: unknown ip operator \"%s\"", rname, operator)
+				os.Exit(100)
+			}
+			if result { break }
+		}
+
+	} else if kind == fk_number && len(right.numbers) == 1 {
+		for _, n := range left.numbers {
+			var err error
+			result, err = compareUInt64(n, operator, right.numbers[0])
+			if err != nil {
+				log.Criticalf("Check field \"%s\" error: %s", rname, err)
+				os.Exit(100)
+			}
+			if result { break }
+		}
+
+	} else if kind == fk_string && len(right.strings) == 1 {
+		for _, s := range left.strings {
+			// empty right side would mean "field is not empty" in compileString
+			if len(operator) == 0 && len(right.strings[0]) == 0 {
+				result = len(s) == 0
+			} else {
+				test, err := c.compileString(operator, right.strings[0])
+				if err != nil {
+					log.Criticalf("Check field \"%s\" error: %s", rname, err)
+					os.Exit(100)
+				}
+				result = test(s)
+			}
+			if result { break }
+		}
+	}
+
+	log.Tracef("Check session 0x%x: fields %s \"%s\" %s -> %t\n", session.Serial, lside.Name, operator, rside.Name, result)
+	if reverse { return !result }
+	return result
+}
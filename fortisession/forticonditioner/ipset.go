@@ -0,0 +1,159 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"bufio"
+	"strings"
+	"math/big"
+	"foset/common"
+)
+
+// ipSet holds the IP addresses, subnets and ranges loaded from file,
+// IPv4 and IPv6 prefixes are kept in separate binary tries.
+type ipSet struct {
+	filename  string
+	v4        *ipTrieNode
+	v6        *ipTrieNode
+	entries   int
+}
+
+type ipTrieNode struct {
+	child     [2]*ipTrieNode
+	terminal  bool  // the prefix ending in this node is in the set
+}
+
+// loadIPSet reads the file with one entry per line. Entry can be IP address, subnet in CIDR
+// notation or range of addresses ("10.0.0.1-10.0.0.50"). Empty lines and everything
+// after `#` are ignored. The file can be compressed.
+func loadIPSet(filename string) (*ipSet, error) {
+	f, err := os.Open(filename)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	reader, _, err := common.Decompress(f)
+	if err != nil { return nil, err }
+	defer reader.Close()
+
+	set := ipSet {
+		filename : filename,
+		v4       : &ipTrieNode{},
+		v6       : &ipTrieNode{},
+	}
+
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		entry := scanner.Text()
+		if i := strings.IndexByte(entry, '#'); i != -1 { entry = entry[:i] }
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 { continue }
+
+		prefixes, err := parseIPSetEntry(entry)
+		if err != nil { return nil, fmt.Errorf("line %d: %s", line, err) }
+
+		for _, p := range prefixes { set.add(p) }
+		set.entries += 1
+	}
+	if err := scanner.Err(); err != nil { return nil, err }
+
+	return &set, nil
+}
+
+// parseIPSetEntry returns the list of subnets covering the entry
+func parseIPSetEntry(entry string) ([]*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, subnet, err := net.ParseCIDR(entry)
+		if err != nil { return nil, fmt.Errorf("invalid subnet \"%s\"", entry) }
+		return []*net.IPNet{ subnet }, nil
+
+	} else if strings.Contains(entry, "-") {
+		parts := strings.SplitN(entry, "-", 2)
+		first := normalizeIP(net.ParseIP(strings.TrimSpace(parts[0])))
+		last  := normalizeIP(net.ParseIP(strings.TrimSpace(parts[1])))
+		if first == nil || last == nil || len(first) != len(last) {
+			return nil, fmt.Errorf("invalid range \"%s\"", entry)
+		}
+		return rangeToPrefixes(first, last)
+
+	} else {
+		ip := normalizeIP(net.ParseIP(entry))
+		if ip == nil { return nil, fmt.Errorf("invalid IP address \"%s\"", entry) }
+		return []*net.IPNet{ &net.IPNet{ IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8) } }, nil
+	}
+}
+
+// rangeToPrefixes splits the range of addresses to the smallest number of subnets
+func rangeToPrefixes(first net.IP, last net.IP) ([]*net.IPNet, error) {
+	bits  := len(first) * 8
+	start := new(big.Int).SetBytes(first)
+	end   := new(big.Int).SetBytes(last)
+	if start.Cmp(end) > 0 { return nil, fmt.Errorf("range start %s is after its end %s", first, last) }
+
+	prefixes := make([]*net.IPNet, 0)
+	one      := big.NewInt(1)
+
+	for start.Cmp(end) <= 0 {
+		// the biggest block aligned to the start that does not go past the end
+		size := 0
+		for size < bits && start.Bit(size) == 0 {
+			blockEnd := new(big.Int).Add(start, new(big.Int).Lsh(one, uint(size+1)))
+			if blockEnd.Sub(blockEnd, one).Cmp(end) > 0 { break }
+			size += 1
+		}
+
+		ip := make(net.IP, len(first))
+		b  := start.Bytes()
+		copy(ip[len(ip)-len(b):], b)
+		prefixes = append(prefixes, &net.IPNet{ IP: ip, Mask: net.CIDRMask(bits-size, bits) })
+
+		start.Add(start, new(big.Int).Lsh(one, uint(size)))
+	}
+
+	return prefixes, nil
+}
+
+// normalizeIP returns 4 bytes long IPv4 address or 16 bytes long IPv6 address
+func normalizeIP(ip net.IP) net.IP {
+	if ip == nil { return nil }
+	if v4 := ip.To4(); v4 != nil { return v4 }
+	return ip.To16()
+}
+
+func (set *ipSet) add(subnet *net.IPNet) {
+	ip := normalizeIP(subnet.IP)
+	ones, _ := subnet.Mask.Size()
+
+	node := set.v6
+	if len(ip) == net.IPv4len { node = set.v4 }
+	// IPv4-mapped IPv6 subnet
+	if len(ip) == net.IPv4len && ones > 32 { ones -= 96 }
+
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if node.child[bit] == nil { node.child[bit] = &ipTrieNode{} }
+		node = node.child[bit]
+	}
+	node.terminal = true
+}
+
+// Contains returns true if the IP address is covered by any entry in the set
+func (set *ipSet) Contains(ip net.IP) bool {
+	ip = normalizeIP(ip)
+	if ip == nil { return false }
+
+	node := set.v6
+	if len(ip) == net.IPv4len { node = set.v4 }
+
+	for i := 0; node != nil; i++ {
+		if node.terminal { return true }
+		if i == len(ip)*8 { break }
+		node = node.child[(ip[i/8] >> (7 - uint(i%8))) & 1]
+	}
+	return false
+}
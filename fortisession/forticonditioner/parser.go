@@ -0,0 +1,441 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+	"foset/fortisession/fortiformatter"
+)
+
+// FilterError is returned by Init when the filter string cannot be parsed.
+// Column is counted from 1 and points to the place where the problem was found.
+type FilterError struct {
+	Filter   string
+	Column   int
+	Message  string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+// Pointer returns the filter string with the mark under the problematic column
+// on the next line
+func (e *FilterError) Pointer() string {
+	return e.Filter + "\n" + strings.Repeat(" ", e.Column-1) + "^"
+}
+
+// field_names are all the field names (including aliases) accepted by lookupField,
+// they are used to suggest the correct name when the field is not known
+var field_names []string
+
+func init() {
+	for _, f := range fortifields.All() {
+		if f.Filter { field_names = append(field_names, f.Names()...) }
+	}
+}
+
+var number_operators = []string{ "", "=", "==", "eq", "is", "!=", "<>", "ne", "not", "<", "lt", "<=", "le", ">", "gt", ">=", "ge", "in" }
+var ip_operators     = []string{ "", "=", "==", "is", "!=", "<>", "not", "in" }
+var string_operators = []string{ "", "=", "==", "eq", "is", "!=", "<>", "ne", "not", "prefix", "starts", "start",
+	"suffix", "ends", "end", "contains", "contain", "c", "has", "~", "regex" }
+
+// filterWord is one word of the condition, quoted words can contain spaces and keywords
+type filterWord struct {
+	text    string
+	quoted  bool
+	pos     int    // byte offset in the filter string
+}
+
+// filterParser reads the filter string character by character. "Or" has precedence
+// over "and", so the top level is the list of "and" parts made of "or" parts.
+type filterParser struct {
+	filter   string
+	pos      int
+	depth    int    // number of open parentheses
+	request  *fortisession.SessionDataRequest
+	cond     *Condition
+}
+
+func (p *filterParser) errorAt(pos int, format string, a ...interface{}) *FilterError {
+	if pos > len(p.filter) { pos = len(p.filter) }
+	return &FilterError {
+		Filter  : p.filter,
+		Column  : utf8.RuneCountInString(p.filter[:pos]) + 1,
+		Message : fmt.Sprintf(format, a...),
+	}
+}
+
+func (p *filterParser) skipSpaces() {
+	for p.pos < len(p.filter) && isFilterSpace(p.filter[p.pos]) { p.pos += 1 }
+}
+
+func isFilterSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// atKeyword returns true if the keyword (case insensitive) is at the current position
+// and it is followed by space, parenthesis or the end of filter
+func (p *filterParser) atKeyword(keyword string) bool {
+	end := p.pos + len(keyword)
+	if end > len(p.filter) || !strings.EqualFold(p.filter[p.pos:end], keyword) { return false }
+	return end == len(p.filter) || isFilterSpace(p.filter[end]) || p.filter[end] == '('
+}
+
+// acceptKeyword skips the keyword if it is at the current position
+func (p *filterParser) acceptKeyword(keyword string) bool {
+	p.skipSpaces()
+	if !p.atKeyword(keyword) { return false }
+	p.pos += len(keyword)
+	return true
+}
+
+func (p *filterParser) parseAnd() (conditionOrExpression, *FilterError) {
+	var and and
+	for {
+		sub, err := p.parseOr()
+		if err != nil { return nil, err }
+		and.sub = append(and.sub, sub)
+		if !p.acceptKeyword("and") { break }
+	}
+	return and, nil
+}
+
+func (p *filterParser) parseOr() (conditionOrExpression, *FilterError) {
+	var or or
+	for {
+		sub, err := p.parseGroup()
+		if err != nil { return nil, err }
+		or.sub = append(or.sub, sub)
+		if !p.acceptKeyword("or") { break }
+	}
+	return or, nil
+}
+
+// parseGroup parses either the condition in parentheses or one expression
+func (p *filterParser) parseGroup() (conditionOrExpression, *FilterError) {
+	p.skipSpaces()
+	if p.pos >= len(p.filter) { return nil, p.errorAt(p.pos, "missing condition") }
+
+	if p.filter[p.pos] == '(' {
+		open := p.pos
+		p.pos   += 1
+		p.depth += 1
+
+		sub, err := p.parseAnd()
+		if err != nil { return nil, err }
+
+		p.skipSpaces()
+		if p.pos >= len(p.filter) || p.filter[p.pos] != ')' {
+			return nil, p.errorAt(open, "missing closing parenthesis")
+		}
+		p.pos   += 1
+		p.depth -= 1
+		return sub, nil
+	}
+
+	if p.filter[p.pos] == ')' {
+		return nil, p.errorAt(p.pos, "missing condition")
+	}
+
+	return p.parseExpression()
+}
+
+// parseExpression reads the words up to the next "and" / "or" keyword or closing parenthesis
+func (p *filterParser) parseExpression() (conditionOrExpression, *FilterError) {
+	words := make([]filterWord, 0)
+
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.filter) { break }
+		if p.filter[p.pos] == ')' { break }
+		if len(words) > 0 && (p.atKeyword("and") || p.atKeyword("or")) { break }
+
+		word, err := p.readWord()
+		if err != nil { return nil, err }
+		words = append(words, word)
+	}
+
+	if p.pos < len(p.filter) && p.filter[p.pos] == ')' && p.depth == 0 {
+		return nil, p.errorAt(p.pos, "unexpected closing parenthesis")
+	}
+
+	c, err := p.makeExpression(words)
+	if err != nil { return nil, err }
+
+	// original text is kept for the explanation
+	e := c.(expression)
+	e.text = strings.TrimSpace(p.filter[words[0].pos:p.pos])
+	return e, nil
+}
+
+// readWord reads one word, either quoted with `"` or `'` or ending with space. Quoted word can
+// contain backslash escaped quotation marks and backslashes, other backslashes are kept as they are
+// (so regular expressions do not need to be escaped twice). Parentheses inside the word are only
+// allowed when balanced, the unbalanced closing parenthesis ends the word.
+func (p *filterParser) readWord() (filterWord, *FilterError) {
+	start := p.pos
+	quote := p.filter[p.pos]
+
+	if quote == '"' || quote == '\'' {
+		var text strings.Builder
+		p.pos += 1
+		for {
+			if p.pos >= len(p.filter) { return filterWord{}, p.errorAt(start, "missing closing quotation mark") }
+			c := p.filter[p.pos]
+			if c == quote {
+				p.pos += 1
+				break
+			}
+			if c == '\\' && p.pos+1 < len(p.filter) && (p.filter[p.pos+1] == '"' || p.filter[p.pos+1] == '\'' || p.filter[p.pos+1] == '\\') {
+				p.pos += 1
+				c = p.filter[p.pos]
+			}
+			text.WriteByte(c)
+			p.pos += 1
+		}
+
+		if p.pos < len(p.filter) && !isFilterSpace(p.filter[p.pos]) && p.filter[p.pos] != ')' {
+			return filterWord{}, p.errorAt(p.pos, "missing space after quoted text")
+		}
+		return filterWord{ text: text.String(), quoted: true, pos: start }, nil
+	}
+
+	open := 0
+	for p.pos < len(p.filter) && !isFilterSpace(p.filter[p.pos]) {
+		if p.filter[p.pos] == '(' {
+			open += 1
+		} else if p.filter[p.pos] == ')' {
+			if open == 0 { break }
+			open -= 1
+		}
+		p.pos += 1
+	}
+	return filterWord{ text: p.filter[start:p.pos], pos: start }, nil
+}
+
+// makeExpression creates the expression from the words of one condition and checks
+// that the field, operator and value make sense together
+func (p *filterParser) makeExpression(words []filterWord) (conditionOrExpression, *FilterError) {
+	var ret expression
+
+	// negative (or possitive - just for better looking conditions)
+	if len(words) > 1 && !words[0].quoted && (strings.EqualFold(words[0].text, "has") || strings.EqualFold(words[0].text, "is")) {
+		words = words[1:]
+	}
+	if len(words) > 1 && !words[0].quoted && (strings.EqualFold(words[0].text, "not") || strings.EqualFold(words[0].text, "no")) {
+		ret.negative = true
+		words = words[1:]
+	}
+
+	// arithmetic expression (like "count[ob] + count[rb] > 1G")
+	if expr, plain := joinWords(words); plain && isArithmetic(expr) {
+		var err error
+		ret.arith, err = parseArithmetic(expr, p.request)
+		if err != nil { return nil, p.errorAt(words[0].pos, "%s", err) }
+		return ret, nil
+	}
+
+	name := words[0]
+	words = words[1:]
+	lside, exists := lookupField(name.text, p.request)
+	if !exists {
+		if _, output := fortifields.Lookup(name.text); output {
+			return nil, p.errorAt(name.pos, "field \"%s\" can only be used in the output format", name.text)
+		}
+		if suggestion := closestField(name.text); len(suggestion) > 0 {
+			return nil, p.errorAt(name.pos, "unknown field \"%s\", did you mean \"%s\"?", name.text, suggestion)
+		}
+		return nil, p.errorAt(name.pos, "unknown field \"%s\"", name.text)
+	}
+	ret.lside = lside
+
+	// custom variable and raw field access have the name as the next word
+	if lside.Name == "custom" || lside.Name == "raw" {
+		if len(words) == 0 { return nil, p.errorAt(len(p.filter), "missing name after \"%s\"", name.text) }
+		ret.extra = words[0].text
+		words = words[1:]
+	}
+
+	op_pos, value_pos := name.pos, name.pos
+	value := filterWord{}
+	if len(words) == 1 {
+		value = words[0]
+		value_pos = value.pos
+	} else if len(words) >= 2 {
+		ret.operator = words[0].text
+		op_pos = words[0].pos
+		value_pos = words[1].pos
+		value.text, _ = joinWords(words[1:])
+		value.quoted = len(words) == 2 && words[1].quoted
+	}
+	ret.rside = value.text
+
+	// right side can also be formatter expression
+	if !value.quoted && len(ret.rside) > 2 && ret.rside[0] == '|' && ret.rside[len(ret.rside)-1] == '|' {
+		var err error
+		ret.formatter, err = fortiformatter.Init(ret.rside[1:len(ret.rside)-1], p.request)
+		if err != nil { return nil, p.errorAt(value_pos, "cannot initialize nested formatter: %s", err) }
+	}
+
+	// right side can also be another field (like "sport = dport"), unquoted name of any field
+	// is always the field and it is an error when the fields cannot be compared,
+	// the value equal to a field name must be quoted
+	lkind, _ := kindOfField(ret.lside)
+	if ret.formatter == nil && !value.quoted && lkind != fk_none && len(ret.rside) > 0 && !strings.Contains(ret.rside, " ") {
+		if rfield, exists := lookupField(ret.rside, p.request); exists {
+			rkind, single := kindOfField(rfield)
+			if rkind != fk_none && !single {
+				return nil, p.errorAt(value_pos, "field \"%s\" has more values and cannot be on the right side", ret.rside)
+			} else if rkind != fk_none && rkind != lkind {
+				return nil, p.errorAt(value_pos, "cannot compare field \"%s\" (%s) with field \"%s\" (%s), quote the value to compare it as text", name.text, lkind, ret.rside, rkind)
+			} else if rkind != fk_none {
+				ret.rfield      = rfield
+				ret.rfield_name = ret.rside
+			}
+		}
+	}
+
+	if err := p.checkExpression(ret, op_pos, value_pos); err != nil { return nil, err }
+	return ret, nil
+}
+
+// checkExpression verifies the operator and the value, so the mistakes are reported before
+// the first session is matched. It also compiles regular expressions and loads IP sets.
+func (p *filterParser) checkExpression(e expression, op_pos int, value_pos int) *FilterError {
+	op := strings.TrimPrefix(e.operator, "!")
+
+	var kind fieldKind
+	switch e.lside.Kind {
+	case fortifields.KindNumber: kind = fk_number
+	case fortifields.KindIP:     kind = fk_ip
+	case fortifields.KindString: kind = fk_string
+	}
+
+	switch e.lside.Name {
+	case "raw":
+		kind = fk_string
+	case "custom":
+		// type of the custom field is only known when the session is parsed
+		return nil
+	case "started":
+		if e.formatter != nil { return nil }
+		if len(e.rside) == 0 { return p.errorAt(value_pos, "missing time") }
+		if _, err := compileStarted(op, e.rside); err != nil {
+			if strings.HasPrefix(err.Error(), "unknown time operator") { return p.errorAt(op_pos, "%s", err) }
+			return p.errorAt(value_pos, "%s", err)
+		}
+		return nil
+	case "service":
+		if e.formatter != nil { return nil }
+		if len(e.rside) == 0 { return p.errorAt(value_pos, "missing service name") }
+		if _, err := compileService(op, e.rside); err != nil {
+			if strings.HasPrefix(err.Error(), "unknown service operator") { return p.errorAt(op_pos, "%s", err) }
+			return p.errorAt(value_pos, "%s", err)
+		}
+		return nil
+	}
+
+	// fields on the right side are compared directly, "in" operator is only for values
+	if e.rfield_name != "" && op == "in" {
+		return p.errorAt(op_pos, "operator \"%s\" cannot be used to compare with field \"%s\"", e.operator, e.rfield_name)
+	}
+
+	if kind == fk_number && !inList(op, number_operators) {
+		return p.errorAt(op_pos, "unknown number operator \"%s\"", e.operator)
+	} else if kind == fk_ip && !inList(op, ip_operators) {
+		return p.errorAt(op_pos, "unknown ip operator \"%s\"", e.operator)
+	} else if kind == fk_string && !inList(strings.TrimPrefix(op, "#"), string_operators) {
+		return p.errorAt(op_pos, "unknown string operator \"%s\"", e.operator)
+	}
+
+	// value is known only when the session is matched
+	if e.rfield_name != "" || e.formatter != nil { return nil }
+
+	if kind == fk_number {
+		is_rate := e.lside.Values == fortifields.ValuesRate
+		if is_rate && op == "in" { return p.errorAt(op_pos, "operator \"%s\" cannot be used with rate", e.operator) }
+
+		if len(op) == 0 && len(e.rside) == 0 && !is_rate { return nil }
+		if len(e.rside) == 0 { return p.errorAt(value_pos, "missing value") }
+
+		if _, err := compileNumber(e.lside, op, e.rside); err != nil {
+			if is_rate { return p.errorAt(value_pos, "invalid rate \"%s\": %s", e.rside, err) }
+			return p.errorAt(value_pos, "%s", err)
+		}
+
+	} else if kind == fk_ip {
+		if op == "in" && strings.HasPrefix(e.rside, "@") {
+			if err := loadIPSetFile(e, p.cond.ipsets); err != nil { return p.errorAt(value_pos, "%s", err) }
+		} else if op == "in" {
+			if _, _, err := net.ParseCIDR(e.rside); err != nil { return p.errorAt(value_pos, "invalid subnet \"%s\"", e.rside) }
+		} else if net.ParseIP(e.rside) == nil {
+			return p.errorAt(value_pos, "invalid IP address \"%s\"", e.rside)
+		}
+
+	} else if kind == fk_string && isRegexOperator(e.operator) {
+		if err := compileRegexp(e, p.cond.regexps); err != nil { return p.errorAt(value_pos, "%s", err) }
+	}
+
+	return nil
+}
+
+// joinWords returns the words separated by space, the second return value is false
+// if any of the words was quoted
+func joinWords(words []filterWord) (string, bool) {
+	texts := make([]string, len(words))
+	plain := true
+	for i, w := range words {
+		texts[i] = w.text
+		if w.quoted { plain = false }
+	}
+	return strings.Join(texts, " "), plain
+}
+
+// closestField returns the known field name most similar to the unknown one,
+// or empty string if none is similar enough
+func closestField(name string) string {
+	name = strings.ToLower(name)
+	best, best_distance := "", 0
+	for _, f := range field_names {
+		d := editDistance(name, f)
+		if best == "" || d < best_distance {
+			best, best_distance = f, d
+		}
+	}
+
+	// do not suggest completely different names
+	if best_distance > 3 || best_distance >= len(name) { return "" }
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev { prev[j] = j }
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if unicode.ToLower(ra[i-1]) == unicode.ToLower(rb[j-1]) { cost = 0 }
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b { return a }
+	return b
+}
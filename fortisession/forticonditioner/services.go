@@ -0,0 +1,297 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package forticonditioner
+
+import (
+	"os"
+	"fmt"
+	"bufio"
+	"strings"
+	"strconv"
+	"io/ioutil"
+	"foset/common"
+)
+
+// serviceEntry is one IP protocol and destination port range of the service,
+// protocol 0 matches any protocol
+type serviceEntry struct {
+	proto  uint16
+	low    uint16
+	high   uint16
+}
+
+// services are indexed by the lowercase name
+var services = make(map[string][]serviceEntry)
+
+// builtin_services are the most common services, they can be overridden by LoadServices
+var builtin_services = []struct {
+	name   string
+	proto  uint16
+	low    uint16
+	high   uint16
+} {
+	{ "all", 0, 0, 65535 },
+	{ "all_tcp", 6, 0, 65535 },
+	{ "all_udp", 17, 0, 65535 },
+	{ "all_icmp", 1, 0, 65535 },
+	{ "all_icmp6", 58, 0, 65535 },
+	{ "icmp", 1, 0, 65535 },
+	{ "gre", 47, 0, 65535 },
+	{ "esp", 50, 0, 65535 },
+	{ "ah", 51, 0, 65535 },
+	{ "ftp", 6, 21, 21 },
+	{ "ssh", 6, 22, 22 },
+	{ "telnet", 6, 23, 23 },
+	{ "smtp", 6, 25, 25 },
+	{ "dns", 6, 53, 53 },
+	{ "dns", 17, 53, 53 },
+	{ "dhcp", 17, 67, 68 },
+	{ "tftp", 17, 69, 69 },
+	{ "http", 6, 80, 80 },
+	{ "kerberos", 6, 88, 88 },
+	{ "kerberos", 17, 88, 88 },
+	{ "pop3", 6, 110, 110 },
+	{ "ntp", 6, 123, 123 },
+	{ "ntp", 17, 123, 123 },
+	{ "samba", 6, 139, 139 },
+	{ "imap", 6, 143, 143 },
+	{ "snmp", 17, 161, 162 },
+	{ "bgp", 6, 179, 179 },
+	{ "ldap", 6, 389, 389 },
+	{ "ldap_udp", 17, 389, 389 },
+	{ "https", 6, 443, 443 },
+	{ "smb", 6, 445, 445 },
+	{ "smtps", 6, 465, 465 },
+	{ "ike", 17, 500, 500 },
+	{ "ike", 17, 4500, 4500 },
+	{ "syslog", 17, 514, 514 },
+	{ "submission", 6, 587, 587 },
+	{ "ldaps", 6, 636, 636 },
+	{ "imaps", 6, 993, 993 },
+	{ "pop3s", 6, 995, 995 },
+	{ "ms-sql", 6, 1433, 1434 },
+	{ "l2tp", 17, 1701, 1701 },
+	{ "pptp", 6, 1723, 1723 },
+	{ "radius", 17, 1812, 1813 },
+	{ "nfs", 6, 2049, 2049 },
+	{ "nfs", 17, 2049, 2049 },
+	{ "mysql", 6, 3306, 3306 },
+	{ "rdp", 6, 3389, 3389 },
+	{ "sip", 6, 5060, 5060 },
+	{ "sip", 17, 5060, 5060 },
+	{ "postgresql", 6, 5432, 5432 },
+	{ "vnc", 6, 5900, 5900 },
+	{ "http-alt", 6, 8080, 8080 },
+	{ "fortiguard", 6, 8888, 8888 },
+	{ "fortiguard", 17, 8888, 8888 },
+}
+
+func init() {
+	for _, s := range builtin_services {
+		services[s.name] = append(services[s.name], serviceEntry{ proto: s.proto, low: s.low, high: s.high })
+	}
+}
+
+// LoadServices reads the service definitions from file and overrides the services
+// with the same name. The file can be either in "/etc/services" format or FortiOS
+// "config firewall service custom" configuration export. It must be called before Init.
+func LoadServices(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil { return err }
+	defer f.Close()
+
+	reader, _, err := common.Decompress(f)
+	if err != nil { return err }
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil { return err }
+
+	var loaded map[string][]serviceEntry
+	if strings.Contains(string(data), "config firewall service custom") {
+		loaded, err = parseFortiOSServices(string(data))
+	} else {
+		loaded, err = parseEtcServices(string(data))
+	}
+	if err != nil { return fmt.Errorf("%s: %s", filename, err) }
+
+	for name, entries := range loaded { services[name] = entries }
+	log.Debugf("Loaded %d services from \"%s\"", len(loaded), filename)
+	return nil
+}
+
+// parseEtcServices parses the lines like "http 80/tcp www # WorldWideWeb HTTP",
+// the aliases are added as separate services
+func parseEtcServices(data string) (map[string][]serviceEntry, error) {
+	loaded := make(map[string][]serviceEntry)
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i != -1 { text = text[:i] }
+		fields := strings.Fields(text)
+		if len(fields) == 0 { continue }
+		if len(fields) < 2 { return nil, fmt.Errorf("line %d: missing port", line) }
+
+		parts := strings.SplitN(fields[1], "/", 2)
+		if len(parts) != 2 { return nil, fmt.Errorf("line %d: invalid port \"%s\"", line, fields[1]) }
+		port, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil { return nil, fmt.Errorf("line %d: invalid port \"%s\"", line, fields[1]) }
+
+		var proto uint16
+		switch strings.ToLower(parts[1]) {
+		case "tcp":  proto = 6
+		case "udp":  proto = 17
+		case "sctp": proto = 132
+		default:     continue  // ddp and other non-IP protocols
+		}
+
+		entry := serviceEntry{ proto: proto, low: uint16(port), high: uint16(port) }
+		for _, name := range append([]string{ fields[0] }, fields[2:]...) {
+			name = strings.ToLower(name)
+			loaded[name] = append(loaded[name], entry)
+		}
+	}
+
+	return loaded, scanner.Err()
+}
+
+// parseFortiOSServices parses the "config firewall service custom" sections,
+// only the destination port ranges are used
+func parseFortiOSServices(data string) (map[string][]serviceEntry, error) {
+	loaded := make(map[string][]serviceEntry)
+
+	var name, protocol string
+	var entries []serviceEntry
+	var protocol_number uint64
+	depth, section := 0, -1
+
+	finish := func() {
+		if len(name) == 0 { return }
+		switch protocol {
+		case "ICMP":  entries = []serviceEntry{ { proto: 1, low: 0, high: 65535 } }
+		case "ICMP6": entries = []serviceEntry{ { proto: 58, low: 0, high: 65535 } }
+		case "IP":    entries = []serviceEntry{ { proto: uint16(protocol_number), low: 0, high: 65535 } }
+		}
+		if len(entries) > 0 { loaded[strings.ToLower(name)] = entries }
+		name, protocol, entries, protocol_number = "", "", nil, 0
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 { continue }
+
+		if fields[0] == "config" {
+			depth += 1
+			if strings.Join(fields, " ") == "config firewall service custom" { section = depth }
+			continue
+		} else if fields[0] == "end" {
+			if depth == section {
+				finish()
+				section = -1
+			}
+			depth -= 1
+			continue
+		}
+		if depth != section { continue }
+
+		if fields[0] == "edit" && len(fields) >= 2 {
+			finish()
+			name = strings.Trim(strings.Join(fields[1:], " "), "\"")
+		} else if fields[0] == "next" {
+			finish()
+		} else if fields[0] == "set" && len(fields) >= 3 {
+			switch fields[1] {
+			case "protocol":
+				protocol = strings.ToUpper(fields[2])
+			case "protocol-number":
+				n, err := strconv.ParseUint(fields[2], 10, 8)
+				if err != nil { return nil, fmt.Errorf("line %d: invalid protocol number \"%s\"", line, fields[2]) }
+				protocol_number = n
+			case "tcp-portrange", "udp-portrange", "sctp-portrange":
+				proto := map[string]uint16{ "tcp-portrange": 6, "udp-portrange": 17, "sctp-portrange": 132 }[fields[1]]
+				for _, r := range fields[2:] {
+					// destination range can be followed by source range after ":"
+					low, high, err := parsePortRange(strings.SplitN(r, ":", 2)[0])
+					if err != nil { return nil, fmt.Errorf("line %d: %s", line, err) }
+					entries = append(entries, serviceEntry{ proto: proto, low: low, high: high })
+				}
+			}
+		}
+	}
+
+	return loaded, scanner.Err()
+}
+
+// parsePortRange parses the port ("80") or port range ("8000-8100")
+func parsePortRange(r string) (uint16, uint16, error) {
+	parts := strings.SplitN(r, "-", 2)
+	low, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil { return 0, 0, fmt.Errorf("invalid port range \"%s\"", r) }
+	high := low
+	if len(parts) == 2 {
+		high, err = strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || high < low { return 0, 0, fmt.Errorf("invalid port range \"%s\"", r) }
+	}
+	return uint16(low), uint16(high), nil
+}
+
+// lookupService returns the service definition, the name is case insensitive
+func lookupService(name string) ([]serviceEntry, error) {
+	entries, exists := services[strings.ToLower(name)]
+	if exists { return entries, nil }
+
+	// suggest the most similar name
+	best, best_distance := "", 0
+	for known, _ := range services {
+		d := editDistance(strings.ToLower(name), known)
+		if best == "" || d < best_distance || (d == best_distance && known < best) {
+			best, best_distance = known, d
+		}
+	}
+	if best_distance <= 2 && best_distance < len(name) {
+		return nil, fmt.Errorf("unknown service \"%s\", did you mean \"%s\"?", name, best)
+	}
+	return nil, fmt.Errorf("unknown service \"%s\"", name)
+}
+
+// compileService returns the matcher for the session protocol and destination port,
+// the right side is one service name or comma separated list of names (with "in" operator)
+func compileService(operator string, rside string) (func(proto uint16, dport uint16) bool, error) {
+	var names []string
+	if operator == "in" {
+		names = strings.Split(rside, ",")
+	} else if operator == "" || operator == "=" || operator == "==" || operator == "is" ||
+	          operator == "!=" || operator == "<>" || operator == "not" {
+		names = []string{ rside }
+	} else {
+		return nil, fmt.Errorf("unknown service operator \"%s\"", operator)
+	}
+
+	var entries []serviceEntry
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 { return nil, fmt.Errorf("missing service name") }
+		e, err := lookupService(name)
+		if err != nil { return nil, err }
+		entries = append(entries, e...)
+	}
+
+	test := func(proto uint16, dport uint16) bool {
+		for _, e := range entries {
+			if (e.proto == 0 || e.proto == proto) && dport >= e.low && dport <= e.high { return true }
+		}
+		return false
+	}
+
+	if operator == "!=" || operator == "<>" || operator == "not" {
+		return func(proto uint16, dport uint16) bool { return !test(proto, dport) }, nil
+	}
+	return test, nil
+}
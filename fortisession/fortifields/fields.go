@@ -0,0 +1,199 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+// Describe all the session fields, so the filter, the output format and the plugins
+// use the same names, types and data requests.
+package fortifields
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"foset/fortisession"
+)
+
+// Kind is the type of the field value
+type Kind int
+const (
+	KindNumber   Kind = iota
+	KindIP
+	KindString
+	KindSpecial          // field with its own handling in the filter or output format (like "custom")
+)
+
+func (k Kind) String() string {
+	if k == KindNumber { return "number" }
+	if k == KindIP     { return "ip" }
+	if k == KindString { return "string" }
+	return "special"
+}
+
+// Values describes the texts accepted by the filter for the number field besides the plain numbers
+type Values int
+const (
+	ValuesPlain  Values = iota
+	ValuesState          // session state names (like "established")
+	ValuesProto          // IP protocol names (like "tcp")
+	ValuesPolicy         // "internal" policy
+	ValuesPort           // service names (like "https")
+	ValuesRate           // rate with units (like "10 Mbps")
+	ValuesTime           // time with units (like "1h30m")
+)
+
+// Unit is the unit of the number field value used in arithmetic expressions
+type Unit int
+const (
+	UnitPlain    Unit = iota
+	UnitBytes
+	UnitPackets
+	UnitSeconds
+	UnitRate             // bytes per second
+)
+
+// Request is the set of SessionDataRequest parts that must be parsed to know the field value
+type Request uint32
+const (
+	ReqPlain     Request = 1 << iota
+	ReqSerial
+	ReqHooks
+	ReqStates
+	ReqBasics
+	ReqStats
+	ReqRate
+	ReqNpu
+	ReqPolicy
+	ReqOther
+	ReqNpuError
+	ReqShaping
+	ReqMacs
+	ReqInterfaces
+	ReqAuth
+	ReqApp
+	ReqSdwan
+	ReqNpuSession
+	ReqMcast
+	ReqCustom
+	ReqRaw
+)
+
+var request_names = []string{ "plain", "serial", "hooks", "states", "basics", "stats", "rate", "npu", "policy", "other",
+	"npuerror", "shaping", "macs", "interfaces", "auth", "app", "sdwan", "npusession", "mcast", "custom", "raw" }
+
+// Apply sets the requested parts in `request` to `true`, it never sets any part to `false`
+func (r Request) Apply(request *fortisession.SessionDataRequest) {
+	if r & ReqPlain      != 0 { request.Plain      = true }
+	if r & ReqSerial     != 0 { request.Serial     = true }
+	if r & ReqHooks      != 0 { request.Hooks      = true }
+	if r & ReqStates     != 0 { request.States     = true }
+	if r & ReqBasics     != 0 { request.Basics     = true }
+	if r & ReqStats      != 0 { request.Stats      = true }
+	if r & ReqRate       != 0 { request.Rate       = true }
+	if r & ReqNpu        != 0 { request.Npu        = true }
+	if r & ReqPolicy     != 0 { request.Policy     = true }
+	if r & ReqOther      != 0 { request.Other      = true }
+	if r & ReqNpuError   != 0 { request.NpuError   = true }
+	if r & ReqShaping    != 0 { request.Shaping    = true }
+	if r & ReqMacs       != 0 { request.Macs       = true }
+	if r & ReqInterfaces != 0 { request.Interfaces = true }
+	if r & ReqAuth       != 0 { request.Auth       = true }
+	if r & ReqApp        != 0 { request.App        = true }
+	if r & ReqSdwan      != 0 { request.Sdwan      = true }
+	if r & ReqNpuSession != 0 { request.NpuSession = true }
+	if r & ReqMcast      != 0 { request.Mcast      = true }
+	if r & ReqCustom     != 0 { request.Custom     = true }
+	if r & ReqRaw        != 0 { request.Raw        = true }
+}
+
+func (r Request) String() string {
+	var parts []string
+	for i, name := range request_names {
+		if r & (1 << uint(i)) != 0 { parts = append(parts, name) }
+	}
+	if len(parts) == 0 { return "-" }
+	return strings.Join(parts, ",")
+}
+
+// Field describes one session field. Fields with more values (like "host") match the filter
+// when any of the values matches, unless `All` is set (like "npuflag" that needs both directions).
+type Field struct {
+	Name     string
+	Aliases  []string
+	Kind     Kind
+	Multi    bool      // field has more values
+	All      bool      // all the values must match the filter
+	Values   Values
+	Unit     Unit
+	Request  Request
+	Filter   bool      // field can be used in the filter
+	Output   bool      // field can be used in the output format
+	Form     string    // default output format (like "d" or "s")
+	Example  string
+	Doc      string
+
+	// appliers call the test for the field value(s) of the session and return the combined result,
+	// the one matching the Kind is set (none for special fields), values not present in the session
+	// are not tested at all
+	Number   func(session *fortisession.Session, test func(uint64) bool) bool
+	IP       func(session *fortisession.Session, test func(net.IP) bool) bool
+	Text     func(session *fortisession.Session, test func(string) bool) bool
+}
+
+// Names returns the name of the field followed by all its aliases
+func (f *Field) Names() []string {
+	return append([]string{ f.Name }, f.Aliases...)
+}
+
+var by_name map[string]*Field
+
+func init() {
+	by_name = make(map[string]*Field)
+	for _, f := range fields {
+		for _, name := range f.Names() {
+			if _, exists := by_name[name]; exists { panic("field \"" + name + "\" is defined twice") }
+			by_name[name] = f
+		}
+	}
+}
+
+// Lookup returns the field by its name or alias, the second value is false if there is no such field
+func Lookup(name string) (*Field, bool) {
+	f, exists := by_name[name]
+	return f, exists
+}
+
+// All returns all the fields in the order they are documented
+func All() []*Field {
+	return fields
+}
+
+// Require sets the parts of `request` needed by the named fields,
+// it is used by the plugins that read the session fields directly
+func Require(request *fortisession.SessionDataRequest, names ...string) error {
+	for _, name := range names {
+		f, exists := Lookup(name)
+		if !exists { return fmt.Errorf("unknown field \"%s\"", name) }
+		f.Request.Apply(request)
+	}
+	return nil
+}
+
+// List returns the text table describing all the fields
+func List() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("%-14s %-8s %-6s %-6s %-20s %-18s %s\n", "NAME", "TYPE", "FILTER", "OUTPUT", "ALIASES", "EXAMPLE", "DESCRIPTION"))
+
+	for _, f := range fields {
+		kind := f.Kind.String()
+		if f.Multi { kind += "s" }
+		aliases := strings.Join(f.Aliases, " ")
+		if len(aliases) == 0 { aliases = "-" }
+		s.WriteString(fmt.Sprintf("%-14s %-8s %-6s %-6s %-20s %-18s %s\n", f.Name, kind, yesNo(f.Filter), yesNo(f.Output), aliases, f.Example, f.Doc))
+	}
+
+	return s.String()
+}
+
+func yesNo(b bool) string {
+	if b { return "yes" }
+	return "no"
+}
@@ -0,0 +1,426 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package fortifields
+
+import (
+	"net"
+	"foset/fortisession"
+)
+
+// session is shorter to write in the table bellow
+type session = fortisession.Session
+
+// number, ip and text create the appliers for the fields with one value
+func number(get func(s *session) uint64) func(*session, func(uint64) bool) bool {
+	return func(s *session, test func(uint64) bool) bool { return test(get(s)) }
+}
+
+func ip(get func(s *session) net.IP) func(*session, func(net.IP) bool) bool {
+	return func(s *session, test func(net.IP) bool) bool { return test(get(s)) }
+}
+
+func text(get func(s *session) string) func(*session, func(string) bool) bool {
+	return func(s *session, test func(string) bool) bool { return test(get(s)) }
+}
+
+// boolean is 1 for true and 0 for false
+func boolean(b bool) uint64 {
+	if b { return 1 }
+	return 0
+}
+
+// idleSeconds returns how long the session has not seen any traffic, which is the configured
+// timeout minus the remaining expiration time (each packet sets the expiration back to the timeout)
+func idleSeconds(s *session) uint64 {
+	if s.Basics.Expire > s.Basics.Timeout { return 0 }
+	return s.Basics.Timeout - s.Basics.Expire
+}
+
+// fields are all the known fields, new field added here can be used everywhere
+// (the filter, output format, `--fields` listing)
+var fields = []*Field{
+	// addresses and ports
+	{ Name: "host", Kind: KindIP, Multi: true, Request: ReqHooks, Filter: true,
+		Example: "1.2.3.4", Doc: "source or destination or natted IP address",
+		IP: func(s *session, test func(net.IP) bool) bool {
+			src_ip, _, dst_ip, _, nat_ip, _, _ := s.GetPeers()
+			return test(src_ip) || test(dst_ip) || test(nat_ip)
+		} },
+	{ Name: "shost", Aliases: []string{ "sa" }, Kind: KindIP, Request: ReqHooks, Filter: true, Output: true, Form: "s",
+		Example: "1.2.3.4", Doc: "source host IP address",
+		IP: ip(func(s *session) net.IP { src_ip, _, _, _, _, _, _ := s.GetPeers(); return src_ip }) },
+	{ Name: "dhost", Aliases: []string{ "da" }, Kind: KindIP, Request: ReqHooks, Filter: true, Output: true, Form: "s",
+		Example: "1.2.3.4", Doc: "destination host IP address",
+		IP: ip(func(s *session) net.IP { _, _, dst_ip, _, _, _, _ := s.GetPeers(); return dst_ip }) },
+	{ Name: "nhost", Aliases: []string{ "na" }, Kind: KindIP, Request: ReqHooks, Filter: true, Output: true, Form: "s",
+		Example: "1.2.3.4", Doc: "natted IP address (0.0.0.0 if no NAT is applied)",
+		IP: ip(func(s *session) net.IP { _, _, _, _, nat_ip, _, _ := s.GetPeers(); return nat_ip }) },
+	{ Name: "port", Kind: KindNumber, Multi: true, Values: ValuesPort, Request: ReqHooks, Filter: true,
+		Example: "53", Doc: "source or destination or natted port",
+		Number: func(s *session, test func(uint64) bool) bool {
+			_, src_port, _, dst_port, _, nat_port, _ := s.GetPeers()
+			return test(uint64(src_port)) || test(uint64(dst_port)) || test(uint64(nat_port))
+		} },
+	{ Name: "sport", Aliases: []string{ "sp" }, Kind: KindNumber, Values: ValuesPort, Request: ReqHooks, Filter: true, Output: true, Form: "d",
+		Example: "65342", Doc: "source port",
+		Number: number(func(s *session) uint64 { _, src_port, _, _, _, _, _ := s.GetPeers(); return uint64(src_port) }) },
+	{ Name: "dport", Aliases: []string{ "dp" }, Kind: KindNumber, Values: ValuesPort, Request: ReqHooks, Filter: true, Output: true, Form: "d",
+		Example: "80", Doc: "destination port",
+		Number: number(func(s *session) uint64 { _, _, _, dst_port, _, _, _ := s.GetPeers(); return uint64(dst_port) }) },
+	{ Name: "nport", Aliases: []string{ "np" }, Kind: KindNumber, Values: ValuesPort, Request: ReqHooks, Filter: true, Output: true, Form: "d",
+		Example: "33440", Doc: "natted port (0 if no NAT is applied)",
+		Number: number(func(s *session) uint64 { _, _, _, _, _, nat_port, _ := s.GetPeers(); return uint64(nat_port) }) },
+	{ Name: "ipver", Kind: KindNumber, Filter: true, Output: true, Form: "d",
+		Example: "6", Doc: "IP version of the session (4 or 6)",
+		Number: number(func(s *session) uint64 { if s.Ipv6 { return 6 }; return 4 }) },
+
+	// policy and states
+	{ Name: "policy", Kind: KindNumber, Values: ValuesPolicy, Request: ReqPolicy, Filter: true, Output: true, Form: "d",
+		Example: "10", Doc: "policy number (\"internal\" in filter, \"i\" in output)",
+		Number: number(func(s *session) uint64 { return uint64(s.Policy.Id) }) },
+	{ Name: "vdom", Kind: KindNumber, Request: ReqPolicy, Filter: true, Output: true, Form: "d",
+		Example: "1", Doc: "VDOM number",
+		Number: number(func(s *session) uint64 { return uint64(s.Policy.Vdom) }) },
+	{ Name: "helper", Kind: KindString, Request: ReqOther, Filter: true, Output: true, Form: "s",
+		Example: "dns-udp", Doc: "helper name",
+		Text: text(func(s *session) string { return s.Other.Helper }) },
+	{ Name: "state", Kind: KindString, Multi: true, Request: ReqStates, Filter: true, Output: true, Form: "s",
+		Example: "log-start", Doc: "session states (the filter matches any of them)",
+		Text: func(s *session, test func(string) bool) bool {
+			for _, state := range s.States {
+				if test(string(state)) { return true }
+			}
+			return false
+		} },
+	{ Name: "status[l]", Aliases: []string{ "state[l]" }, Kind: KindNumber, Values: ValuesState, Request: ReqBasics, Filter: true, Output: true, Form: "s",
+		Example: "established", Doc: "client to FortiGate (left) session state",
+		Number: number(func(s *session) uint64 { return uint64(s.Basics.StateL) }) },
+	{ Name: "status[r]", Aliases: []string{ "state[r]" }, Kind: KindNumber, Values: ValuesState, Request: ReqBasics, Filter: true, Output: true, Form: "s",
+		Example: "established", Doc: "FortiGate to server (right) session state",
+		Number: number(func(s *session) uint64 { return uint64(s.Basics.StateR) }) },
+	{ Name: "status", Kind: KindNumber, Multi: true, Values: ValuesState, Request: ReqBasics, Filter: true,
+		Example: "established", Doc: "either left or right session state",
+		Number: func(s *session, test func(uint64) bool) bool {
+			return test(uint64(s.Basics.StateL)) || test(uint64(s.Basics.StateR))
+		} },
+	{ Name: "proto", Aliases: []string{ "protocol" }, Kind: KindNumber, Values: ValuesProto, Request: ReqBasics, Filter: true, Output: true, Form: "s",
+		Example: "tcp", Doc: "IP protocol",
+		Number: number(func(s *session) uint64 { return uint64(s.Basics.Protocol) }) },
+	{ Name: "serial", Aliases: []string{ "session" }, Kind: KindNumber, Request: ReqSerial, Filter: true, Output: true, Form: "x",
+		Example: "0xea1fa572", Doc: "session serial number",
+		Number: number(func(s *session) uint64 { return s.Serial }) },
+
+	// NPU offload
+	{ Name: "npuflag[o]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "#x",
+		Example: "0x81", Doc: "NPU flag for original direction",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.Flag_org) }) },
+	{ Name: "npuflag[r]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "#x",
+		Example: "0x81", Doc: "NPU flag for reverse direction",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.Flag_rev) }) },
+	{ Name: "npuflag", Kind: KindNumber, Multi: true, All: true, Request: ReqNpu, Filter: true,
+		Example: "0x81", Doc: "NPU flag for both original and reverse direction",
+		Number: func(s *session, test func(uint64) bool) bool {
+			return test(uint64(s.Npu.Flag_org)) && test(uint64(s.Npu.Flag_rev))
+		} },
+	{ Name: "offload[o]", Aliases: []string{ "offloaded[o]" }, Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "8", Doc: "NPU offload type for original direction (output \"Y\" or \"N\" as string)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.Offload_org) }) },
+	{ Name: "offload[r]", Aliases: []string{ "offloaded[r]" }, Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "8", Doc: "NPU offload type for reverse direction (output \"Y\" or \"N\" as string)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.Offload_rev) }) },
+	{ Name: "offload", Aliases: []string{ "offloaded" }, Kind: KindNumber, Multi: true, All: true, Request: ReqNpu, Filter: true,
+		Example: "8", Doc: "NPU offload type for both original and reverse direction",
+		Number: func(s *session, test func(uint64) bool) bool {
+			return test(uint64(s.Npu.Offload_org)) && test(uint64(s.Npu.Offload_rev))
+		} },
+	{ Name: "nturbo[o]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "1", Doc: "nTurbo type for original direction (output \"Y\" or \"N\" as string)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.Nturbo_org) }) },
+	{ Name: "nturbo[r]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "1", Doc: "nTurbo type for reverse direction (output \"Y\" or \"N\" as string)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.Nturbo_rev) }) },
+	{ Name: "nturbo", Kind: KindNumber, Multi: true, All: true, Request: ReqNpu, Filter: true,
+		Example: "1", Doc: "nTurbo type for both original and reverse direction",
+		Number: func(s *session, test func(uint64) bool) bool {
+			return test(uint64(s.Npu.Nturbo_org)) && test(uint64(s.Npu.Nturbo_rev))
+		} },
+	{ Name: "nooff[no]", Kind: KindString, Request: ReqNpuError, Filter: true, Output: true, Form: "s",
+		Example: "dirty", Doc: "field \"no_ofld_reason\"",
+		Text: text(func(s *session) string { return s.NpuError.NoOffloadReason }) },
+	{ Name: "nooff[ko]", Kind: KindString, Request: ReqNpuError, Filter: true, Output: true, Form: "s",
+		Example: "none", Doc: "field \"ofld_fail_reason\": kernel, original direction",
+		Text: text(func(s *session) string { return s.NpuError.Kernel_org }) },
+	{ Name: "nooff[kr]", Kind: KindString, Request: ReqNpuError, Filter: true, Output: true, Form: "s",
+		Example: "not-established", Doc: "field \"ofld_fail_reason\": kernel, reverse direction",
+		Text: text(func(s *session) string { return s.NpuError.Kernel_rev }) },
+	{ Name: "nooff[do]", Kind: KindString, Request: ReqNpuError, Filter: true, Output: true, Form: "s",
+		Example: "none(0)", Doc: "field \"ofld_fail_reason\": driver, original direction",
+		Text: text(func(s *session) string { return s.NpuError.Driver_org }) },
+	{ Name: "nooff[dr]", Kind: KindString, Request: ReqNpuError, Filter: true, Output: true, Form: "s",
+		Example: "none(0)", Doc: "field \"ofld_fail_reason\": driver, reverse direction",
+		Text: text(func(s *session) string { return s.NpuError.Driver_rev }) },
+	{ Name: "innpu[o]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "20", Doc: "NPU ID of the original incoming NPU (output \"-\" if unknown)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.InNpu_org) }) },
+	{ Name: "innpu[f]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "21", Doc: "NPU ID of the forwarded to incoming NPU (output \"-\" if unknown)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.InNpu_fwd) }) },
+	{ Name: "outnpu[o]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "20", Doc: "NPU ID of the original outgoing NPU (output \"-\" if unknown)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.OutNpu_org) }) },
+	{ Name: "outnpu[f]", Kind: KindNumber, Request: ReqNpu, Filter: true, Output: true, Form: "s",
+		Example: "21", Doc: "NPU ID of the forwarded to outgoing NPU (output \"-\" if unknown)",
+		Number: number(func(s *session) uint64 { return uint64(s.Npu.OutNpu_fwd) }) },
+
+	// rates and counters
+	{ Name: "rate[u]", Aliases: []string{ "upload" }, Kind: KindNumber, Values: ValuesRate, Unit: UnitRate, Request: ReqRate, Filter: true, Output: true, Form: "s",
+		Example: "10 Mbps", Doc: "data rate in upload (original) direction",
+		Number: number(func(s *session) uint64 { return s.Rate.Tx_Bps }) },
+	{ Name: "rate[d]", Aliases: []string{ "download" }, Kind: KindNumber, Values: ValuesRate, Unit: UnitRate, Request: ReqRate, Filter: true, Output: true, Form: "s",
+		Example: "1 MBps", Doc: "data rate in download (reverse) direction",
+		Number: number(func(s *session) uint64 { return s.Rate.Rx_Bps }) },
+	{ Name: "rate", Kind: KindNumber, Multi: true, Values: ValuesRate, Unit: UnitRate, Request: ReqRate, Filter: true,
+		Example: "100 KBps", Doc: "data rate in either upload or download direction",
+		Number: func(s *session, test func(uint64) bool) bool { return test(s.Rate.Tx_Bps) || test(s.Rate.Rx_Bps) } },
+	{ Name: "rate[sum]", Kind: KindNumber, Values: ValuesRate, Unit: UnitRate, Request: ReqRate, Filter: true, Output: true, Form: "s",
+		Example: "1 Gbps", Doc: "total data rate of both directions",
+		Number: number(func(s *session) uint64 { return s.Rate.Tx_Bps + s.Rate.Rx_Bps }) },
+	// counters are not present in all sessions
+	{ Name: "count[ob]", Aliases: []string{ "stats[ob]" }, Kind: KindNumber, Unit: UnitBytes, Request: ReqStats, Filter: true, Output: true, Form: "s",
+		Example: "10000", Doc: "bytes forwarded in original direction (output \"?\" if unknown)",
+		Number: func(s *session, test func(uint64) bool) bool { return s.Stats.Valid_org && test(s.Stats.Bytes_org) } },
+	{ Name: "count[op]", Aliases: []string{ "stats[op]" }, Kind: KindNumber, Unit: UnitPackets, Request: ReqStats, Filter: true, Output: true, Form: "s",
+		Example: "10", Doc: "packets forwarded in original direction (output \"?\" if unknown)",
+		Number: func(s *session, test func(uint64) bool) bool { return s.Stats.Valid_org && test(s.Stats.Packets_org) } },
+	{ Name: "count[oe]", Aliases: []string{ "stats[oe]" }, Kind: KindNumber, Unit: UnitPackets, Request: ReqStats, Filter: true, Output: true, Form: "s",
+		Example: "1", Doc: "errors in original direction (output \"?\" if unknown)",
+		Number: func(s *session, test func(uint64) bool) bool { return s.Stats.Valid_org && test(s.Stats.Errors_org) } },
+	{ Name: "count[rb]", Aliases: []string{ "stats[rb]" }, Kind: KindNumber, Unit: UnitBytes, Request: ReqStats, Filter: true, Output: true, Form: "s",
+		Example: "10000", Doc: "bytes forwarded in reverse direction (output \"?\" if unknown)",
+		Number: func(s *session, test func(uint64) bool) bool { return s.Stats.Valid_rev && test(s.Stats.Bytes_rev) } },
+	{ Name: "count[rp]", Aliases: []string{ "stats[rp]" }, Kind: KindNumber, Unit: UnitPackets, Request: ReqStats, Filter: true, Output: true, Form: "s",
+		Example: "10", Doc: "packets forwarded in reverse direction (output \"?\" if unknown)",
+		Number: func(s *session, test func(uint64) bool) bool { return s.Stats.Valid_rev && test(s.Stats.Packets_rev) } },
+	{ Name: "count[re]", Aliases: []string{ "stats[re]" }, Kind: KindNumber, Unit: UnitPackets, Request: ReqStats, Filter: true, Output: true, Form: "s",
+		Example: "1", Doc: "errors in reverse direction (output \"?\" if unknown)",
+		Number: func(s *session, test func(uint64) bool) bool { return s.Stats.Valid_rev && test(s.Stats.Errors_rev) } },
+
+	// other
+	{ Name: "haid", Kind: KindNumber, Request: ReqOther, Filter: true, Output: true, Form: "d",
+		Example: "0", Doc: "HA ID",
+		Number: number(func(s *session) uint64 { return uint64(s.Other.HAid) }) },
+	{ Name: "shapingpolicy", Kind: KindNumber, Request: ReqOther, Filter: true, Output: true, Form: "s",
+		Example: "1", Doc: "shaping policy id (output \"-\" as string when no shaping is done)",
+		Number: number(func(s *session) uint64 { return uint64(s.Other.ShapingPolicyId) }) },
+	{ Name: "tunnel[i]", Kind: KindString, Request: ReqOther, Filter: true, Output: true, Form: "s",
+		Example: "test", Doc: "name of IPSec tunnel the session came from",
+		Text: text(func(s *session) string { return s.Other.Tunnel_in }) },
+	{ Name: "tunnel[o]", Kind: KindString, Request: ReqOther, Filter: true, Output: true, Form: "s",
+		Example: "test", Doc: "name of IPSec tunnel the session goes to",
+		Text: text(func(s *session) string { return s.Other.Tunnel_out }) },
+	{ Name: "tunnel", Kind: KindString, Multi: true, Request: ReqOther, Filter: true,
+		Example: "test", Doc: "name of either incoming or outgoing IPSec tunnel",
+		Text: func(s *session, test func(string) bool) bool { return test(s.Other.Tunnel_in) || test(s.Other.Tunnel_out) } },
+	{ Name: "shaper[o]", Kind: KindString, Request: ReqShaping, Filter: true, Output: true, Form: "s",
+		Example: "shaperA", Doc: "name of shaper applied in original direction",
+		Text: text(func(s *session) string { return s.Shaping.Shaper_org }) },
+	{ Name: "shaper[r]", Kind: KindString, Request: ReqShaping, Filter: true, Output: true, Form: "s",
+		Example: "shaperB", Doc: "name of shaper applied in reverse direction",
+		Text: text(func(s *session) string { return s.Shaping.Shaper_rev }) },
+	{ Name: "shaper[ip]", Aliases: []string{ "shaper[pip]" }, Kind: KindString, Request: ReqShaping, Filter: true, Output: true, Form: "s",
+		Example: "shaperC", Doc: "name of per-source-ip shaper",
+		Text: text(func(s *session) string { return s.Shaping.Shaper_ip }) },
+	{ Name: "shaper", Kind: KindString, Multi: true, Request: ReqShaping, Filter: true,
+		Example: "shaperD", Doc: "name of any type of shaper applied",
+		Text: func(s *session, test func(string) bool) bool {
+			return test(s.Shaping.Shaper_org) || test(s.Shaping.Shaper_rev) || test(s.Shaping.Shaper_ip)
+		} },
+	{ Name: "mac[i]", Aliases: []string{ "mac[src]", "smac" }, Kind: KindString, Request: ReqMacs, Filter: true, Output: true, Form: "s",
+		Example: "00:01:02:03:04:05", Doc: "incoming (\"source\") MAC address",
+		Text: text(func(s *session) string { return s.Macs.Src }) },
+	{ Name: "mac[o]", Aliases: []string{ "mac[dst]", "dmac" }, Kind: KindString, Request: ReqMacs, Filter: true, Output: true, Form: "s",
+		Example: "00:01:02:03:04:05", Doc: "outgoing (\"destination\") MAC address",
+		Text: text(func(s *session) string { return s.Macs.Dst }) },
+	{ Name: "mac", Kind: KindString, Multi: true, Request: ReqMacs, Filter: true,
+		Example: "00:01:02:03:04:05", Doc: "either incoming or outgoing MAC address",
+		Text: func(s *session, test func(string) bool) bool { return test(s.Macs.Src) || test(s.Macs.Dst) } },
+
+	// interfaces
+	{ Name: "iface[oi]", Aliases: []string{ "iface[io]" }, Kind: KindNumber, Request: ReqInterfaces, Filter: true, Output: true, Form: "d",
+		Example: "102", Doc: "incoming interface index in original direction",
+		Number: number(func(s *session) uint64 { return uint64(s.Interfaces.In_org) }) },
+	{ Name: "iface[oo]", Kind: KindNumber, Request: ReqInterfaces, Filter: true, Output: true, Form: "d",
+		Example: "103", Doc: "outgoing interface index in original direction",
+		Number: number(func(s *session) uint64 { return uint64(s.Interfaces.Out_org) }) },
+	{ Name: "iface[ri]", Aliases: []string{ "iface[ir]" }, Kind: KindNumber, Request: ReqInterfaces, Filter: true, Output: true, Form: "d",
+		Example: "103", Doc: "incoming interface index in reverse direction",
+		Number: number(func(s *session) uint64 { return uint64(s.Interfaces.In_rev) }) },
+	{ Name: "iface[ro]", Aliases: []string{ "iface[or]" }, Kind: KindNumber, Request: ReqInterfaces, Filter: true, Output: true, Form: "d",
+		Example: "102", Doc: "outgoing interface index in reverse direction",
+		Number: number(func(s *session) uint64 { return uint64(s.Interfaces.Out_rev) }) },
+	{ Name: "iface", Kind: KindNumber, Multi: true, Request: ReqInterfaces, Filter: true,
+		Example: "102", Doc: "incoming or outgoing interface index in any direction",
+		Number: func(s *session, test func(uint64) bool) bool {
+			return test(uint64(s.Interfaces.In_org)) || test(uint64(s.Interfaces.Out_org)) ||
+			       test(uint64(s.Interfaces.In_rev)) || test(uint64(s.Interfaces.Out_rev))
+		} },
+	{ Name: "nexthop[o]", Aliases: []string{ "nh[o]" }, Kind: KindIP, Request: ReqInterfaces, Filter: true, Output: true, Form: "s",
+		Example: "1.2.3.4", Doc: "next hop in original direction",
+		IP: ip(func(s *session) net.IP { return s.Interfaces.NextHop_org }) },
+	{ Name: "nexthop[r]", Aliases: []string{ "nh[r]" }, Kind: KindIP, Request: ReqInterfaces, Filter: true, Output: true, Form: "s",
+		Example: "1.2.3.4", Doc: "next hop in reverse direction",
+		IP: ip(func(s *session) net.IP { return s.Interfaces.NextHop_rev }) },
+	{ Name: "nexthop", Aliases: []string{ "nh" }, Kind: KindIP, Multi: true, Request: ReqInterfaces, Filter: true,
+		Example: "1.2.3.4", Doc: "next hop in any direction",
+		IP: func(s *session, test func(net.IP) bool) bool {
+			return test(s.Interfaces.NextHop_org) || test(s.Interfaces.NextHop_rev)
+		} },
+
+	// authentication, applications and SD-WAN
+	{ Name: "user", Kind: KindString, Request: ReqAuth, Filter: true, Output: true, Form: "s",
+		Example: "someuser", Doc: "user name of authenticated user",
+		Text: text(func(s *session) string { return s.Auth.User }) },
+	{ Name: "authserver", Kind: KindString, Request: ReqAuth, Filter: true, Output: true, Form: "s",
+		Example: "ourldap", Doc: "authentication server profile name",
+		Text: text(func(s *session) string { return s.Auth.Profile }) },
+	{ Name: "authinfo", Kind: KindNumber, Request: ReqAuth, Filter: true, Output: true, Form: "d",
+		Example: "3", Doc: "field \"auth_info\"",
+		Number: number(func(s *session) uint64 { return s.Auth.AuthInfo }) },
+	{ Name: "applist", Kind: KindNumber, Request: ReqApp, Filter: true, Output: true, Form: "d",
+		Example: "2000", Doc: "application control list id",
+		Number: number(func(s *session) uint64 { return uint64(s.App.List) }) },
+	{ Name: "app", Kind: KindNumber, Request: ReqApp, Filter: true, Output: true, Form: "d",
+		Example: "15895", Doc: "application id",
+		Number: number(func(s *session) uint64 { return uint64(s.App.Id) }) },
+	{ Name: "urlcat", Kind: KindNumber, Request: ReqApp, Filter: true, Output: true, Form: "d",
+		Example: "52", Doc: "URL category id",
+		Number: number(func(s *session) uint64 { return uint64(s.App.UrlCat) }) },
+	{ Name: "sdwanmbr", Kind: KindNumber, Request: ReqSdwan, Filter: true, Output: true, Form: "d",
+		Example: "2", Doc: "SD-WAN member sequence number",
+		Number: number(func(s *session) uint64 { return uint64(s.Sdwan.MemberSeq) }) },
+	{ Name: "sdwansvc", Kind: KindNumber, Request: ReqSdwan, Filter: true, Output: true, Form: "d",
+		Example: "3", Doc: "SD-WAN service (rule) id",
+		Number: number(func(s *session) uint64 { return uint64(s.Sdwan.ServiceId) }) },
+	{ Name: "rpdblink", Kind: KindNumber, Request: ReqSdwan, Filter: true, Output: true, Form: "x",
+		Example: "0x80000003", Doc: "policy route database link id",
+		Number: number(func(s *session) uint64 { return uint64(s.Sdwan.RpdbLinkId) }) },
+
+	// hardware sessions
+	{ Name: "npusession", Kind: KindNumber, Request: ReqNpuSession, Filter: true, Output: true, Form: "d",
+		Example: "1", Doc: "1 for hardware (NPU table) session, 0 otherwise",
+		Number: number(func(s *session) uint64 { return boolean(s.NpuSession.Valid) }) },
+	{ Name: "npuid", Kind: KindNumber, Request: ReqNpuSession, Filter: true, Output: true, Form: "d",
+		Example: "0", Doc: "NPU id of the hardware session",
+		Number: number(func(s *session) uint64 { return uint64(s.NpuSession.Id) }) },
+	{ Name: "npuhash", Kind: KindNumber, Request: ReqNpuSession, Filter: true, Output: true, Form: "x",
+		Example: "0x0001a2b3", Doc: "hash of the hardware session",
+		Number: number(func(s *session) uint64 { return uint64(s.NpuSession.Hash) }) },
+	{ Name: "npuaction", Kind: KindString, Request: ReqNpuSession, Filter: true, Output: true, Form: "s",
+		Example: "fwd", Doc: "action of the hardware session",
+		Text: text(func(s *session) string { return s.NpuSession.Action }) },
+	{ Name: "npupkts[o]", Kind: KindNumber, Unit: UnitPackets, Request: ReqNpuSession, Filter: true, Output: true, Form: "d",
+		Example: "12", Doc: "hardware packets in original direction",
+		Number: number(func(s *session) uint64 { return s.NpuSession.Packets_org }) },
+	{ Name: "npupkts[r]", Kind: KindNumber, Unit: UnitPackets, Request: ReqNpuSession, Filter: true, Output: true, Form: "d",
+		Example: "10", Doc: "hardware packets in reverse direction",
+		Number: number(func(s *session) uint64 { return s.NpuSession.Packets_rev }) },
+	{ Name: "npubytes[o]", Kind: KindNumber, Unit: UnitBytes, Request: ReqNpuSession, Filter: true, Output: true, Form: "d",
+		Example: "1200", Doc: "hardware bytes in original direction",
+		Number: number(func(s *session) uint64 { return s.NpuSession.Bytes_org }) },
+	{ Name: "npubytes[r]", Kind: KindNumber, Unit: UnitBytes, Request: ReqNpuSession, Filter: true, Output: true, Form: "d",
+		Example: "5600", Doc: "hardware bytes in reverse direction",
+		Number: number(func(s *session) uint64 { return s.NpuSession.Bytes_rev }) },
+
+	// multicast
+	{ Name: "mcast", Kind: KindNumber, Request: ReqMcast, Filter: true, Output: true, Form: "d",
+		Example: "1", Doc: "1 for multicast session, 0 otherwise",
+		Number: number(func(s *session) uint64 { return boolean(s.Mcast.Valid) }) },
+	{ Name: "mcastid", Kind: KindNumber, Request: ReqMcast, Filter: true, Output: true, Form: "d",
+		Example: "0", Doc: "multicast session id",
+		Number: number(func(s *session) uint64 { return uint64(s.Mcast.Id) }) },
+	{ Name: "mcastgroup", Kind: KindIP, Request: ReqMcast, Filter: true, Output: true, Form: "s",
+		Example: "239.1.1.1", Doc: "multicast group (destination) address",
+		IP: ip(func(s *session) net.IP { return s.Mcast.Group }) },
+	{ Name: "mcastsrc", Kind: KindIP, Request: ReqMcast, Filter: true, Output: true, Form: "s",
+		Example: "10.1.1.1", Doc: "multicast source address",
+		IP: ip(func(s *session) net.IP { return s.Mcast.Source }) },
+	{ Name: "mcastin", Kind: KindNumber, Request: ReqMcast, Filter: true, Output: true, Form: "d",
+		Example: "5", Doc: "multicast incoming interface index",
+		Number: number(func(s *session) uint64 { return uint64(s.Mcast.InDev) }) },
+	{ Name: "mcastout", Kind: KindNumber, Multi: true, Request: ReqMcast, Filter: true, Output: true, Form: "s",
+		Example: "6", Doc: "multicast outgoing interface indexes (the filter matches any of them)",
+		Number: func(s *session, test func(uint64) bool) bool {
+			for _, dev := range s.Mcast.OutDevs {
+				if test(uint64(dev)) { return true }
+			}
+			return false
+		} },
+	{ Name: "mcastpkts", Kind: KindNumber, Unit: UnitPackets, Request: ReqMcast, Filter: true, Output: true, Form: "d",
+		Example: "1000", Doc: "multicast packet counter",
+		Number: number(func(s *session) uint64 { return s.Mcast.Packets }) },
+
+	// input
+	{ Name: "snapshot", Kind: KindNumber, Filter: true, Output: true, Form: "d",
+		Example: "2", Doc: "index of the session dump in the file (from 1)",
+		// snapshot is not known when the session was not read from file
+		Number: number(func(s *session) uint64 {
+			if s.Snapshot == nil { return 0 }
+			return uint64(s.Snapshot.Index)
+		}) },
+	{ Name: "snaptime", Kind: KindSpecial, Output: true, Form: "s",
+		Example: "2020-05-01 10:05:00", Doc: "time of the session dump (if found in the file)" },
+	{ Name: "source", Kind: KindString, Filter: true, Output: true, Form: "s",
+		Example: "fw2", Doc: "input file the session was read from",
+		Text: text(func(s *session) string { return s.Source }) },
+
+	// times
+	{ Name: "duration", Kind: KindNumber, Values: ValuesTime, Unit: UnitSeconds, Request: ReqBasics, Filter: true, Output: true, Form: "d",
+		Example: "2h", Doc: "session duration (seconds)",
+		Number: number(func(s *session) uint64 { return s.Basics.Duration }) },
+	{ Name: "expire", Kind: KindNumber, Values: ValuesTime, Unit: UnitSeconds, Request: ReqBasics, Filter: true, Output: true, Form: "d",
+		Example: "3599", Doc: "time until the session expires (seconds)",
+		Number: number(func(s *session) uint64 { return s.Basics.Expire }) },
+	{ Name: "timeout", Kind: KindNumber, Values: ValuesTime, Unit: UnitSeconds, Request: ReqBasics, Filter: true, Output: true, Form: "d",
+		Example: "3600", Doc: "configured session timeout (seconds)",
+		Number: number(func(s *session) uint64 { return s.Basics.Timeout }) },
+	{ Name: "idle", Kind: KindNumber, Values: ValuesTime, Unit: UnitSeconds, Request: ReqBasics, Filter: true, Output: true, Form: "d",
+		Example: "10m", Doc: "time since the last packet (timeout - expire)",
+		Number: number(idleSeconds) },
+	{ Name: "started", Kind: KindSpecial, Request: ReqBasics, Filter: true,
+		Example: "2026-10-16T08:00", Doc: "time the session was started (needs the time of the session dump)" },
+	{ Name: "det", Kind: KindSpecial, Request: ReqBasics, Output: true, Form: "s",
+		Example: "70/3529 (3600)", Doc: "duration, expire and timeout together" },
+
+	// special fields
+	{ Name: "service", Kind: KindSpecial, Request: ReqBasics | ReqHooks, Filter: true,
+		Example: "dns", Doc: "protocol and destination port match the service" },
+	{ Name: "custom", Kind: KindSpecial, Request: ReqCustom, Filter: true, Output: true, Form: "s",
+		Example: "custom name", Doc: "custom variable set by plugin (name follows in the filter, \"|name\" in the output)" },
+	{ Name: "raw", Kind: KindSpecial, Request: ReqRaw, Filter: true, Output: true, Form: "s",
+		Example: "raw name", Doc: "any field from the session dump (name follows in the filter, \"|name\" in the output)" },
+
+	// output only
+	{ Name: "sap", Kind: KindSpecial, Request: ReqHooks, Output: true, Form: "s",
+		Example: "1.2.3.4:65342", Doc: "source address and port" },
+	{ Name: "dap", Kind: KindSpecial, Request: ReqHooks, Output: true, Form: "s",
+		Example: "8.8.8.8:53", Doc: "destination address and port" },
+	{ Name: "nap", Kind: KindSpecial, Request: ReqHooks, Output: true, Form: "s",
+		Example: "10.20.30.40:33440", Doc: "natted address and port" },
+	{ Name: "sdap", Kind: KindSpecial, Request: ReqHooks, Output: true, Form: "s",
+		Example: "1.2.3.4:65342->8.8.8.8:53", Doc: "source and destination address and port" },
+	{ Name: "count[o]", Kind: KindSpecial, Request: ReqStats, Output: true, Form: "s",
+		Example: "390/3/0", Doc: "bytes, packets and errors in original direction" },
+	{ Name: "count[r]", Kind: KindSpecial, Request: ReqStats, Output: true, Form: "s",
+		Example: "60/1/0", Doc: "bytes, packets and errors in reverse direction" },
+	{ Name: "tunnels", Kind: KindSpecial, Request: ReqOther, Output: true, Form: "s",
+		Example: "test->-", Doc: "incoming and outgoing IPSec tunnel" },
+	{ Name: "patho", Kind: KindSpecial, Request: ReqInterfaces, Output: true, Form: "s",
+		Example: "102->103 1.2.3.4", Doc: "interfaces and next hop in original direction" },
+	{ Name: "pathr", Kind: KindSpecial, Request: ReqInterfaces, Output: true, Form: "s",
+		Example: "103->102 1.2.3.4", Doc: "interfaces and next hop in reverse direction" },
+	{ Name: "plain", Kind: KindSpecial, Request: ReqPlain, Output: true, Form: "s",
+		Example: "whole session", Doc: "the original text of the session" },
+	{ Name: "newline", Kind: KindSpecial, Output: true, Form: "s",
+		Example: "\\n", Doc: "new line to create multiline outputs" },
+}
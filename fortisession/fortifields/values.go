@@ -0,0 +1,77 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package fortifields
+
+import (
+	"fmt"
+	"strings"
+)
+
+var proto_names = map[uint16]string{ 1: "ICMP", 6: "TCP", 17: "UDP", 41: "IPv6", 47: "GRE", 50: "ESP" }
+
+// ProtoName returns the name of the IP protocol or its number if the name is not known
+func ProtoName(proto uint16) string {
+	if name, exists := proto_names[proto]; exists { return name }
+	return fmt.Sprintf("%d", proto)
+}
+
+// ProtoNumber returns the number of the IP protocol name (case insensitive),
+// the second value is false if the name is not known
+func ProtoNumber(name string) (uint64, bool) {
+	for number, n := range proto_names {
+		if strings.EqualFold(n, name) { return uint64(number), true }
+	}
+	return 0, false
+}
+
+// from https://kb.fortinet.com/kb/viewContent.do?externalId=FD30042
+// partially validated (the numbers differ from Linux kernel)
+var tcp_states  = []string{ "NONE", "ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT", "TIME_WAIT", "CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN" }
+var udp_states  = []string{ "UNSEEN", "SEEN" }
+var sctp_states = []string{ "NONE", "ESTABLISHED", "CLOSED", "COOKIE_WAIT", "COOKIE_ECHOED", "SHUTDOWN_SENT", "SHUTDOWN_RECD", "SHUTDOWN_ACK_SENT", "MAX" }
+
+// state_abbreviations are the short names accepted by StateNumber
+var state_abbreviations = map[string]uint64{ "ss": 2, "sr": 3, "fw": 4, "fw1": 4, "fw2": 4, "fin_wait1": 4, "fin_wait2": 4, "tw": 5, "cw": 7, "la": 8, "li": 9 }
+
+// StateName returns the name of the session state for the protocol, "UNKNOWN" if the state
+// number is not known and the number itself for protocols without states
+func StateName(proto uint16, state uint8) string {
+	var names []string
+	if proto == 6 {
+		names = tcp_states
+	} else if proto == 17 {
+		names = udp_states
+	} else if proto == 132 {
+		names = sctp_states
+	} else {
+		return fmt.Sprintf("%d", state)
+	}
+
+	if int(state) < len(names) { return names[state] }
+	return "UNKNOWN"
+}
+
+// StateNumber returns the number of the TCP or UDP session state name, case insensitive and "-"
+// can be used instead of "_". Unique beginning of the TCP state name is enough (like "est").
+func StateNumber(name string) (uint64, bool) {
+	name = strings.ToUpper(strings.Replace(name, "-", "_", -1))
+	if len(name) == 0 { return 0, false }
+
+	for _, names := range [][]string{ tcp_states, udp_states } {
+		for i, n := range names {
+			if n == name { return uint64(i), true }
+		}
+	}
+
+	if n, exists := state_abbreviations[strings.ToLower(name)]; exists { return n, true }
+
+	found := -1
+	for i, n := range tcp_states {
+		if !strings.HasPrefix(n, name) { continue }
+		if found != -1 { return 0, false }
+		found = i
+	}
+	if found == -1 { return 0, false }
+	return uint64(found), true
+}
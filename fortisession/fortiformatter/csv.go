@@ -0,0 +1,86 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package fortiformatter
+
+import (
+	"fmt"
+	"strings"
+	"encoding/csv"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+)
+
+// InitCSV initializes the Formatter that prints the values of the listed variables
+// as CSV rows (or TSV rows with `tab` set) with the header row returned by Header.
+//
+// The `columns` is comma separated list of the variables as they are written inside
+// of `${...}` in the format string (like "serial,sa,rate[sum]:d,custom|vdom"). Only the
+// parameters after "|" can contain commas (as long as the text after the comma is not
+// a variable name), the comma after ":" format always starts the next column.
+// Values containing the separator, quotes or new lines are quoted.
+func InitCSV(columns string, tab bool, request *fortisession.SessionDataRequest) (*Formatter, error) {
+	f := Formatter{ empty: "-", comma: ',' }
+	if tab { f.comma = '\t' }
+
+	names := splitColumns(columns)
+	if len(names) == 0 { return nil, fmt.Errorf("No columns specified") }
+
+	for _, name := range names {
+		if strings.ContainsAny(name, "${}") {
+			return nil, fmt.Errorf("Column \"%s\" must be the variable name without \"${}\"", name)
+		}
+
+		column, err := Init("${" + name + "}", request)
+		if err != nil { return nil, err }
+		if len(column.vars) != 1 {
+			return nil, fmt.Errorf("Column \"%s\" is not a single variable", name)
+		}
+
+		f.columns = append(f.columns, column)
+	}
+
+	f.header = f.csvRow(names)
+	return &f, nil
+}
+
+// splitColumns splits the comma separated columns, the part not starting with
+// the variable name is considered to be a part of the previous column modifier
+func splitColumns(columns string) []string {
+	var names []string
+
+	for _, part := range strings.Split(columns, ",") {
+		name := strings.TrimSpace(part)
+		if i := strings.IndexAny(name, ":|"); i != -1 { name = name[:i] }
+
+		if _, exists := fortifields.Lookup(name); !exists && len(names) > 0 && strings.Contains(names[len(names)-1], "|") {
+			names[len(names)-1] += "," + part
+			continue
+		}
+
+		if len(strings.TrimSpace(part)) == 0 { continue }
+		names = append(names, strings.TrimSpace(part))
+	}
+
+	return names
+}
+
+// formatCSV returns the CSV row with the values of all the columns
+func (f *Formatter) formatCSV(session *fortisession.Session) string {
+	values := make([]string, len(f.columns))
+	for i, column := range f.columns {
+		values[i] = column.Format(session)
+	}
+	return f.csvRow(values)
+}
+
+// csvRow returns the values joined by the separator and quoted where necessary
+func (f *Formatter) csvRow(values []string) string {
+	var s strings.Builder
+	w := csv.NewWriter(&s)
+	w.Comma = f.comma
+	w.Write(values)
+	w.Flush()
+
+	return strings.TrimSuffix(s.String(), "\n")
+}
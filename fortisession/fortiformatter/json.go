@@ -0,0 +1,352 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package fortiformatter
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"encoding/json"
+	"foset/fortisession"
+	"foset/fortisession/fortifields"
+)
+
+// JSON schema of one session, see "JSON output" in README.md in this directory.
+// Each section is only present if it was requested (by the output format,
+// by the filter or by the plugins), the keys inside of the section are always present.
+type jsonSession struct {
+	Serial      string                 `json:"serial,omitempty"`
+	IpVer       uint8                  `json:"ipver"`
+	Source      string                 `json:"source,omitempty"`
+	Snapshot    *jsonSnapshot          `json:"snapshot,omitempty"`
+	Hooks       []jsonHook             `json:"hooks,omitempty"`
+	States      []string               `json:"states,omitempty"`
+	Basics      *jsonBasics            `json:"basics,omitempty"`
+	Stats       *jsonStats             `json:"stats,omitempty"`
+	Rate        *jsonRate              `json:"rate,omitempty"`
+	Npu         *jsonNpu               `json:"npu,omitempty"`
+	Policy      *jsonPolicy            `json:"policy,omitempty"`
+	Other       *jsonOther             `json:"other,omitempty"`
+	NpuError    *jsonNpuError          `json:"npu_error,omitempty"`
+	Shaping     *jsonShaping           `json:"shaping,omitempty"`
+	Macs        *jsonMacs              `json:"macs,omitempty"`
+	Interfaces  *jsonInterfaces        `json:"interfaces,omitempty"`
+	Auth        *jsonAuth              `json:"auth,omitempty"`
+	App         *jsonApp               `json:"app,omitempty"`
+	Sdwan       *jsonSdwan             `json:"sdwan,omitempty"`
+	NpuSession  *jsonNpuSession        `json:"npu_session,omitempty"`
+	Mcast       *jsonMcast             `json:"mcast,omitempty"`
+	Custom      map[string]interface{} `json:"custom,omitempty"`
+	Raw         map[string]string      `json:"raw,omitempty"`
+	Plain       string                 `json:"plain,omitempty"`
+}
+
+type jsonSnapshot struct {
+	Index       uint32     `json:"index"`
+	Time        string     `json:"time,omitempty"`
+}
+
+type jsonAddress struct {
+	Ip          net.IP     `json:"ip"`
+	Port        uint16     `json:"port"`
+}
+
+type jsonHook struct {
+	Hook        string      `json:"hook"`
+	Dir         string      `json:"dir"`
+	Act         string      `json:"act"`
+	Src         jsonAddress `json:"src"`
+	Dst         jsonAddress `json:"dst"`
+	Nat         jsonAddress `json:"nat"`
+}
+
+type jsonBasics struct {
+	Proto       uint16     `json:"proto"`
+	ProtoName   string     `json:"proto_name"`
+	StateL      uint8      `json:"state_l"`
+	StateLName  string     `json:"state_l_name"`
+	StateR      uint8      `json:"state_r"`
+	StateRName  string     `json:"state_r_name"`
+	Duration    uint64     `json:"duration"`
+	Expire      uint64     `json:"expire"`
+	Timeout     uint64     `json:"timeout"`
+}
+
+// counters of the direction that was not found in the session are omitted
+type jsonStats struct {
+	BytesOrg    *uint64    `json:"bytes_org,omitempty"`
+	PacketsOrg  *uint64    `json:"packets_org,omitempty"`
+	ErrorsOrg   *uint64    `json:"errors_org,omitempty"`
+	BytesRev    *uint64    `json:"bytes_rev,omitempty"`
+	PacketsRev  *uint64    `json:"packets_rev,omitempty"`
+	ErrorsRev   *uint64    `json:"errors_rev,omitempty"`
+}
+
+type jsonRate struct {
+	Upload      uint64     `json:"upload_Bps"`
+	Download    uint64     `json:"download_Bps"`
+}
+
+// NPU IDs that were not found in the session are omitted
+type jsonNpu struct {
+	OffloadOrg  uint8      `json:"offload_org"`
+	OffloadRev  uint8      `json:"offload_rev"`
+	NturboOrg   uint8      `json:"nturbo_org"`
+	NturboRev   uint8      `json:"nturbo_rev"`
+	FlagOrg     uint8      `json:"flag_org"`
+	FlagRev     uint8      `json:"flag_rev"`
+	InNpuOrg    *uint8     `json:"innpu_org,omitempty"`
+	InNpuFwd    *uint8     `json:"innpu_fwd,omitempty"`
+	OutNpuOrg   *uint8     `json:"outnpu_org,omitempty"`
+	OutNpuFwd   *uint8     `json:"outnpu_fwd,omitempty"`
+}
+
+type jsonPolicy struct {
+	Id          uint32     `json:"id"`
+	Vdom        uint32     `json:"vdom"`
+}
+
+type jsonOther struct {
+	HAid           uint8   `json:"haid"`
+	Helper         string  `json:"helper"`
+	ShapingPolicy  uint32  `json:"shaping_policy"`
+	TunnelIn       string  `json:"tunnel_in"`
+	TunnelOut      string  `json:"tunnel_out"`
+}
+
+type jsonNpuError struct {
+	NoOffloadReason string `json:"no_offload_reason"`
+	KernelOrg       string `json:"kernel_org"`
+	KernelRev       string `json:"kernel_rev"`
+	DriverOrg       string `json:"driver_org"`
+	DriverRev       string `json:"driver_rev"`
+}
+
+type jsonShaping struct {
+	ShaperOrg   string     `json:"shaper_org"`
+	ShaperRev   string     `json:"shaper_rev"`
+	ShaperIp    string     `json:"shaper_ip"`
+}
+
+type jsonMacs struct {
+	Src         string     `json:"src"`
+	Dst         string     `json:"dst"`
+}
+
+type jsonInterfaces struct {
+	InOrg       uint32     `json:"in_org"`
+	OutOrg      uint32     `json:"out_org"`
+	InRev       uint32     `json:"in_rev"`
+	OutRev      uint32     `json:"out_rev"`
+	NextHopOrg  net.IP     `json:"nexthop_org"`
+	NextHopRev  net.IP     `json:"nexthop_rev"`
+}
+
+type jsonAuth struct {
+	User        string     `json:"user"`
+	Profile     string     `json:"profile"`
+	Info        uint64     `json:"info"`
+}
+
+type jsonApp struct {
+	List        uint32     `json:"list"`
+	Id          uint32     `json:"id"`
+	UrlCat      uint32     `json:"url_cat"`
+}
+
+type jsonSdwan struct {
+	MemberSeq   uint32     `json:"member_seq"`
+	ServiceId   uint32     `json:"service_id"`
+	RpdbLinkId  uint32     `json:"rpdb_link_id"`
+}
+
+type jsonNpuSession struct {
+	Valid       bool       `json:"valid"`
+	Id          uint32     `json:"id"`
+	Hash        uint32     `json:"hash"`
+	Action      string     `json:"action"`
+	PacketsOrg  uint64     `json:"packets_org"`
+	PacketsRev  uint64     `json:"packets_rev"`
+	BytesOrg    uint64     `json:"bytes_org"`
+	BytesRev    uint64     `json:"bytes_rev"`
+}
+
+type jsonMcast struct {
+	Valid       bool       `json:"valid"`
+	Id          uint32     `json:"id"`
+	Source      net.IP     `json:"source"`
+	Group       net.IP     `json:"group"`
+	InDev       uint32     `json:"in_dev"`
+	OutDevs     []uint32   `json:"out_devs"`
+	Packets     uint64     `json:"packets"`
+}
+
+// InitJSON initializes the Formatter that prints each session as one JSON object.
+// With `array` set, all the sessions are printed as one JSON array (see Header, Separator
+// and Footer), otherwise there is one object per line (NDJSON).
+//
+// The `format` string is only used to decide which parts of the session are requested
+// (like with Init), only those parts are included in the JSON objects.
+func InitJSON(format string, array bool, request *fortisession.SessionDataRequest) (*Formatter, error) {
+	f, err := Init(format, request)
+	if err != nil { return nil, err }
+
+	f.request = request
+	if array {
+		f.header    = "["
+		f.separator = ","
+		f.footer    = "]"
+	}
+
+	return f, nil
+}
+
+// formatJSON returns the session as JSON object
+func (f *Formatter) formatJSON(session *fortisession.Session) string {
+	data, err := json.Marshal(f.jsonSession(session))
+	if err != nil {
+		log.Errorf("Cannot encode session %x to JSON: %s", session.Serial, err)
+		return "{}"
+	}
+	return string(data)
+}
+
+// jsonSession converts the parts of the session that were requested to the JSON schema
+func (f *Formatter) jsonSession(session *fortisession.Session) *jsonSession {
+	r := f.request
+	j := jsonSession{ IpVer: 4, Source: session.Source }
+	if session.Ipv6 { j.IpVer = 6 }
+
+	if r.Serial { j.Serial = fmt.Sprintf("%08x", session.Serial) }
+	if r.Plain  { j.Plain  = session.Plain }
+
+	if session.Snapshot != nil {
+		j.Snapshot = &jsonSnapshot{ Index: session.Snapshot.Index }
+		if !session.Snapshot.Time.IsZero() { j.Snapshot.Time = session.Snapshot.Time.Format(time.RFC3339) }
+	}
+
+	if r.Hooks {
+		j.Hooks = make([]jsonHook, 0, len(session.Hooks))
+		for _, h := range session.Hooks {
+			j.Hooks = append(j.Hooks, jsonHook{
+				Hook: h.Hook, Dir: h.Dir, Act: h.Act,
+				Src: jsonAddress{ h.Src.Ip, h.Src.Port },
+				Dst: jsonAddress{ h.Dst.Ip, h.Dst.Port },
+				Nat: jsonAddress{ h.Nat.Ip, h.Nat.Port },
+			})
+		}
+	}
+
+	if r.States {
+		j.States = make([]string, 0, len(session.States))
+		for _, s := range session.States { j.States = append(j.States, string(s)) }
+	}
+
+	if r.Basics && session.Basics != nil {
+		b := session.Basics
+		j.Basics = &jsonBasics{
+			Proto: b.Protocol, ProtoName: fortifields.ProtoName(b.Protocol),
+			StateL: b.StateL, StateLName: fortifields.StateName(b.Protocol, b.StateL),
+			StateR: b.StateR, StateRName: fortifields.StateName(b.Protocol, b.StateR),
+			Duration: b.Duration, Expire: b.Expire, Timeout: b.Timeout,
+		}
+	}
+
+	if r.Stats && session.Stats != nil {
+		s := session.Stats
+		j.Stats = &jsonStats{}
+		if s.Valid_org { j.Stats.BytesOrg, j.Stats.PacketsOrg, j.Stats.ErrorsOrg = &s.Bytes_org, &s.Packets_org, &s.Errors_org }
+		if s.Valid_rev { j.Stats.BytesRev, j.Stats.PacketsRev, j.Stats.ErrorsRev = &s.Bytes_rev, &s.Packets_rev, &s.Errors_rev }
+	}
+
+	if r.Rate && session.Rate != nil {
+		j.Rate = &jsonRate{ Upload: session.Rate.Tx_Bps, Download: session.Rate.Rx_Bps }
+	}
+
+	if r.Npu && session.Npu != nil {
+		n := session.Npu
+		j.Npu = &jsonNpu{
+			OffloadOrg: n.Offload_org, OffloadRev: n.Offload_rev,
+			NturboOrg: n.Nturbo_org, NturboRev: n.Nturbo_rev,
+			FlagOrg: n.Flag_org, FlagRev: n.Flag_rev,
+		}
+		if n.InNpu_org_valid  { j.Npu.InNpuOrg  = &n.InNpu_org  }
+		if n.InNpu_fwd_valid  { j.Npu.InNpuFwd  = &n.InNpu_fwd  }
+		if n.OutNpu_org_valid { j.Npu.OutNpuOrg = &n.OutNpu_org }
+		if n.OutNpu_fwd_valid { j.Npu.OutNpuFwd = &n.OutNpu_fwd }
+	}
+
+	if r.Policy && session.Policy != nil {
+		j.Policy = &jsonPolicy{ Id: session.Policy.Id, Vdom: session.Policy.Vdom }
+	}
+
+	if r.Other && session.Other != nil {
+		o := session.Other
+		j.Other = &jsonOther{ HAid: o.HAid, Helper: o.Helper, ShapingPolicy: o.ShapingPolicyId, TunnelIn: o.Tunnel_in, TunnelOut: o.Tunnel_out }
+	}
+
+	if r.NpuError && session.NpuError != nil {
+		e := session.NpuError
+		j.NpuError = &jsonNpuError{ NoOffloadReason: e.NoOffloadReason, KernelOrg: e.Kernel_org, KernelRev: e.Kernel_rev, DriverOrg: e.Driver_org, DriverRev: e.Driver_rev }
+	}
+
+	if r.Shaping && session.Shaping != nil {
+		s := session.Shaping
+		j.Shaping = &jsonShaping{ ShaperOrg: s.Shaper_org, ShaperRev: s.Shaper_rev, ShaperIp: s.Shaper_ip }
+	}
+
+	if r.Macs && session.Macs != nil {
+		j.Macs = &jsonMacs{ Src: session.Macs.Src, Dst: session.Macs.Dst }
+	}
+
+	if r.Interfaces && session.Interfaces != nil {
+		i := session.Interfaces
+		j.Interfaces = &jsonInterfaces{ InOrg: i.In_org, OutOrg: i.Out_org, InRev: i.In_rev, OutRev: i.Out_rev, NextHopOrg: i.NextHop_org, NextHopRev: i.NextHop_rev }
+	}
+
+	if r.Auth && session.Auth != nil {
+		j.Auth = &jsonAuth{ User: session.Auth.User, Profile: session.Auth.Profile, Info: session.Auth.AuthInfo }
+	}
+
+	if r.App && session.App != nil {
+		j.App = &jsonApp{ List: session.App.List, Id: session.App.Id, UrlCat: session.App.UrlCat }
+	}
+
+	if r.Sdwan && session.Sdwan != nil {
+		j.Sdwan = &jsonSdwan{ MemberSeq: session.Sdwan.MemberSeq, ServiceId: session.Sdwan.ServiceId, RpdbLinkId: session.Sdwan.RpdbLinkId }
+	}
+
+	if r.NpuSession && session.NpuSession != nil {
+		n := session.NpuSession
+		j.NpuSession = &jsonNpuSession{ Valid: n.Valid, Id: n.Id, Hash: n.Hash, Action: n.Action,
+			PacketsOrg: n.Packets_org, PacketsRev: n.Packets_rev, BytesOrg: n.Bytes_org, BytesRev: n.Bytes_rev }
+	}
+
+	if r.Mcast && session.Mcast != nil {
+		m := session.Mcast
+		j.Mcast = &jsonMcast{ Valid: m.Valid, Id: m.Id, Source: m.Source, Group: m.Group, InDev: m.InDev, OutDevs: m.OutDevs, Packets: m.Packets }
+		if j.Mcast.OutDevs == nil { j.Mcast.OutDevs = []uint32{} }
+	}
+
+	if r.Custom && len(session.Custom) > 0 {
+		j.Custom = make(map[string]interface{})
+		for name, value := range session.Custom {
+			if value.IsUint64() {
+				j.Custom[name] = value.GetUint64()
+			} else if value.IsFloat64() {
+				j.Custom[name] = value.GetFloat64()
+			} else if value.IsEmpty() {
+				j.Custom[name] = nil
+			} else {
+				j.Custom[name] = value.AsString()
+			}
+		}
+	}
+
+	if r.Raw && len(session.Raw) > 0 {
+		j.Raw = session.Raw
+	}
+
+	return &j
+}
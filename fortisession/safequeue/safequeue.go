@@ -7,11 +7,19 @@ import (
 	"container/list"
 	"sync"
 	"time"
+	"foset/fortisession"
 	"github.com/juju/loggo"
 )
 
 var log loggo.Logger
 
+// Plain is one plain-text session together with the source and the snapshot it was read from.
+type Plain struct {
+	Data     []byte
+	Source   string
+	Snapshot *fortisession.Snapshot
+}
+
 type SafeQueue struct {
 	plains *list.List
 	lock   *sync.Mutex
@@ -37,8 +45,8 @@ func (p *SafeQueue) Push(plains *list.List) {
 	p.lock.Unlock()
 }
 
-func (p *SafeQueue) Pop(size int) ([][]byte) {
-	plains := make([][]byte, 0)
+func (p *SafeQueue) Pop(size int) ([]Plain) {
+	plains := make([]Plain, 0)
 
 	p.lock.Lock()
 	e := p.plains.Front()
@@ -46,7 +54,7 @@ func (p *SafeQueue) Pop(size int) ([][]byte) {
 	for i := 0; i<size; i++ {
 		if e == nil { break }
 
-		plains = append(plains, e.Value.([]byte))
+		plains = append(plains, e.Value.(Plain))
 		n := e.Next()
 		p.plains.Remove(e)
 		e = n
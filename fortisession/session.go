@@ -2,17 +2,30 @@
 // is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
 
 // Parse one plain text session collected on FortiGate device
-// using `diagnose sys session list` command.
+// using `diagnose sys session list` or `diagnose sys session6 list` command.
 //
-// Currently only IPv4 sessions are supported.
+// Both IPv4 and IPv6 sessions are supported and they can be mixed
+// in the same input.
+//
+// The short format of `get system session list` command (one session
+// per line) is also supported, but it only contains the protocol, expiration
+// and the addresses.
+//
+// Hardware sessions from `diagnose sys npu-session list` (NP7 platforms)
+// are recognized by their "npu-session info:" first line.
+//
+// Multicast sessions from `diagnose sys mcast-session list` are
+// recognized by the "id=" field on their first line.
 package fortisession
 
 import (
+	"fmt"
 	"strconv"
 	"unicode"
 	"bytes"
 	"strings"
 	"net"
+	"time"
 	"github.com/juju/loggo"
 	"foset/fortisession/multivalue"
 )
@@ -119,6 +132,23 @@ type Auth struct {
 	AuthInfo uint64
 }
 
+// App contains the application control and web filter related fields.
+// All values are only IDs as printed in the session, the names must
+// be found in the application and category lists.
+type App struct {
+	List    uint32
+	Id      uint32
+	UrlCat  uint32
+}
+
+// Sdwan contains the SD-WAN member and service used by the session.
+// RpdbLinkId is the policy route database link (hexadecimal in the session).
+type Sdwan struct {
+	MemberSeq   uint32
+	ServiceId   uint32
+	RpdbLinkId  uint32
+}
+
 // Npu contains NPU related fields such as HW offloading or nTurbo.
 // Each value is array where the first number is original direction
 // and the second is reverse.
@@ -152,6 +182,42 @@ type NpuError struct {
 	Driver_rev      string
 }
 
+// NpuSession contains the information from the hardware session table
+// (`diagnose sys npu-session list`). Valid is true only if the session
+// was parsed from NPU session list or if it was correlated with one.
+type NpuSession struct {
+	Valid        bool
+	Id           uint32
+	Hash         uint32
+	Action       string
+	Packets_org  uint64
+	Packets_rev  uint64
+	Bytes_org    uint64
+	Bytes_rev    uint64
+}
+
+// Mcast contains the multicast session information (`diagnose sys mcast-session list`).
+// Valid is true only for multicast sessions. OutDevs contains the outgoing
+// interface indexes of all paths.
+type Mcast struct {
+	Valid    bool
+	Id       uint32
+	Source   net.IP
+	Group    net.IP
+	InDev    uint32
+	OutDevs  []uint32
+	Packets  uint64
+}
+
+// Snapshot identifies the session dump the session was read from, when the input
+// contains multiple dumps (like repeated `diagnose sys session list` in one log).
+// Index starts at 1 and Time is zero if no timestamp was found before the dump.
+// It is not filled by `Parse` but by the code reading the input.
+type Snapshot struct {
+	Index    uint32
+	Time     time.Time
+}
+
 // Policy contains the VDOM id and the policy id within the VDOM.
 type Policy struct {
 	Id      uint32
@@ -166,6 +232,7 @@ type Policy struct {
 type Session struct {
 	Plain      string
 	Serial     uint64
+	Ipv6       bool
 	Hooks      []Hook
 	States     []State
 	Basics     *Basics
@@ -179,8 +246,15 @@ type Session struct {
 	Macs       *Macs
 	Interfaces *Interfaces
 	Auth       *Auth
+	App        *App
+	Sdwan      *Sdwan
+	NpuSession *NpuSession
+	Mcast      *Mcast
 	// aux
+	Source     string  // input file the session was read from (not filled by `Parse`)
+	Snapshot   *Snapshot
 	Custom     map[string]*multivalue.MultiValue
+	Raw        map[string]string
 }
 
 // SessionDataRequest specifies which fields should be extracted by the `Parse` function.
@@ -202,8 +276,13 @@ type SessionDataRequest struct {
 	Macs       bool
 	Interfaces bool
 	Auth       bool
+	App        bool
+	Sdwan      bool
+	NpuSession bool
+	Mcast      bool
 	// aux
 	Custom     bool
+	Raw        bool
 }
 
 // SetAll enables parsing of all possible fields
@@ -224,8 +303,13 @@ func (req *SessionDataRequest) SetAll() {
 	req.Macs       = true
 	req.Interfaces = true
 	req.Auth       = true
+	req.App        = true
+	req.Sdwan      = true
+	req.NpuSession = true
+	req.Mcast      = true
 	// aux
 	req.Custom     = true
+	req.Raw        = true
 }
 
 
@@ -250,6 +334,53 @@ func (session *Session) GetPeers() (src_ip net.IP, src_port uint16, dst_ip net.I
 	return
 }
 
+// ParseError describes one problem found while parsing the session in strict mode.
+//
+// Group is the name of the field in `SessionDataRequest` structure the problem
+// belongs to, Field is the name of the field in the plain-text session and Raw
+// contains the original text that could not be parsed (empty for missing fields).
+type ParseError struct {
+	Serial   uint64
+	Group    string
+	Field    string
+	Raw      string
+	Reason   string
+	Missing  bool
+}
+
+func (e ParseError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("session %08x: %s: field \"%s\" %s", e.Serial, e.Group, e.Field, e.Reason)
+	}
+	return fmt.Sprintf("session %08x: %s: field \"%s\" value \"%s\": %s", e.Serial, e.Group, e.Field, e.Raw, e.Reason)
+}
+
+// parseErrors collects the problems while parsing one session.
+// Nil pointer is used when not running in strict mode and nothing is collected then.
+type parseErrors []ParseError
+
+func (pe *parseErrors) add(group string, field string, raw string, reason string) {
+	if pe == nil { return }
+	*pe = append(*pe, ParseError{ Group: group, Field: field, Raw: raw, Reason: reason })
+}
+
+func (pe *parseErrors) missing(group string, field string) {
+	if pe == nil { return }
+	*pe = append(*pe, ParseError{ Group: group, Field: field, Reason: "is missing", Missing: true })
+}
+
+func (pe *parseErrors) check(err error, group string, field string, raw string) {
+	if err == nil { return }
+	pe.add(group, field, raw, err.Error())
+}
+
+// uint parses the number the same way as strconv.ParseUint, but it records the error
+func (pe *parseErrors) uint(v string, base int, bits int, group string, field string) uint64 {
+	num, err := strconv.ParseUint(v, base, bits)
+	pe.check(err, group, field, v)
+	return num
+}
+
 // Parse takes one plain-text session as byte array and returns
 // one Session structure that contains the information extracted from it.
 //
@@ -257,38 +388,460 @@ func (session *Session) GetPeers() (src_ip net.IP, src_port uint16, dst_ip net.I
 // For fields that are set to `false` Parse will not even try to extract
 // the values.
 func Parse(data []byte, requested *SessionDataRequest) *Session {
+	return parse(data, requested, nil)
+}
+
+// ParseStrict works exactly like `Parse`, but it also returns the list of problems
+// found while parsing the requested fields. The field that cannot be parsed still
+// contains the type's default value in the returned Session.
+//
+// Fields that must be present in every session are reported when missing, other
+// fields (like NPU or authentication) are optional and their absence is not an error.
+// The errors contain the session serial number only if `Serial` was requested.
+func ParseStrict(data []byte, requested *SessionDataRequest) (*Session, []ParseError) {
+	pe := make(parseErrors, 0)
+	s  := parse(data, requested, &pe)
+
+	for i := range pe { pe[i].Serial = s.Serial }
+	return s, pe
+}
+
+func parse(data []byte, requested *SessionDataRequest, pe *parseErrors) *Session {
 	var s Session
 	log.Tracef("Parsing session:\n%s\n---end---\n", string(data))
 
+	// one line from `get system session list`
+	if IsShortSessionLine(data) {
+		return parse_short(data, requested, pe)
+	}
+
+	// hardware session from `diagnose sys npu-session list`
+	if is_npu_session(data) {
+		return parse_npu_session(data, requested, pe)
+	}
+
+	// multicast session from `diagnose sys mcast-session list`
+	if is_mcast_session(data) {
+		return parse_mcast_session(data, requested, pe)
+	}
+
 	// add the final new line to be easily able to match lines
 	if data[len(data)-1] != '\n' {
 		data = append(data, '\n')
 	}
 
-	if requested.Plain      { s.Plain      = string(data[1:])       }
-	if requested.Serial     { s.Serial     = get_serial(&data)      }
-	if requested.States     { s.States     = get_states(&data)      }
-	if requested.Hooks      { s.Hooks      = get_hooks(&data)       }
-	if requested.Basics     { s.Basics     = get_basics(&data)      }
-	if requested.Stats      { s.Stats      = get_stats(&data)       }
-	if requested.Rate       { s.Rate       = get_rate(&data)        }
-	if requested.Npu        { s.Npu        = get_npu(&data)         }
-	if requested.Policy     { s.Policy     = get_policy(&data)      }
-	if requested.Other      { s.Other      = get_other(&data)       }
-	if requested.NpuError   { s.NpuError   = get_npu_error(&data)   }
-	if requested.Shaping    { s.Shaping    = get_shaping(&data)     }
-	if requested.Macs       { s.Macs       = get_macs(&data)        }
-	if requested.Interfaces { s.Interfaces = get_interfaces(&data)  }
-	if requested.Auth       { s.Auth       = get_auth(&data)        }
+	// session family is always known from the first line
+	s.Ipv6 = is_ipv6(data)
+
+	if requested.Plain      { s.Plain      = string(data[1:])           }
+	// must be the first one, because other functions remove parsed lines
+	if requested.Raw        { s.Raw        = get_raw(data)              }
+	// must be before serial, because they share the same line
+	if requested.App        { s.App        = get_app(&data, pe)         }
+	if requested.Serial     { s.Serial     = get_serial(&data, pe)      }
+	if requested.States     { s.States     = get_states(&data)          }
+	if requested.Hooks      { s.Hooks      = get_hooks(&data, pe)       }
+	if requested.Basics     { s.Basics     = get_basics(&data, pe)      }
+	if requested.Stats      { s.Stats      = get_stats(&data, pe)       }
+	if requested.Rate       { s.Rate       = get_rate(&data, pe)        }
+	if requested.Npu        { s.Npu        = get_npu(&data, pe)         }
+	if requested.Policy     { s.Policy     = get_policy(&data, pe)      }
+	if requested.Other      { s.Other      = get_other(&data, pe)       }
+	if requested.NpuError   { s.NpuError   = get_npu_error(&data)       }
+	if requested.Shaping    { s.Shaping    = get_shaping(&data)         }
+	if requested.Macs       { s.Macs       = get_macs(&data)            }
+	if requested.Interfaces { s.Interfaces = get_interfaces(&data, pe)  }
+	if requested.Auth       { s.Auth       = get_auth(&data, pe)        }
+	if requested.Sdwan      { s.Sdwan      = get_sdwan(&data, pe)       }
+	// kernel sessions only get the hardware part from the correlation
+	if requested.NpuSession { s.NpuSession = &NpuSession{}              }
+	if requested.Mcast      { s.Mcast      = &Mcast{}                   }
 	// aux
 	if requested.Custom     { s.Custom = make(map[string]*multivalue.MultiValue)    }
 
 	return &s
 }
 
-func get_serial(data *[]byte) uint64 {
+// short_protocols translates protocol names used in `get system session list` output
+var short_protocols = map[string]uint16 {
+	"icmp": 1, "tcp": 6, "udp": 17, "gre": 47, "esp": 50, "ah": 51,
+	"icmp6": 58, "ospf": 89, "sctp": 132,
+}
+
+// IsShortSessionLine returns true if the line looks like one session from
+// the `get system session list` output, ie.:
+//
+//   PROTO   EXPIRE SOURCE           SOURCE-NAT       DESTINATION      DESTINATION-NAT
+//   tcp     3599   10.0.0.1:52345   1.2.3.4:52345    8.8.8.8:443      -
+func IsShortSessionLine(line []byte) bool {
+	// verbose sessions have more lines, reject them before splitting the whole text
+	if bytes.IndexByte(bytes.Trim(line, "\r\n"), '\n') != -1 { return false }
+
+	fields := bytes.Fields(line)
+	if len(fields) != 6 { return false }
+
+	// expire is always a number
+	for _, c := range fields[1] {
+		if c < '0' || c > '9' { return false }
+	}
+
+	// source and destination are always "ip:port"
+	if bytes.IndexByte(fields[2], ':') == -1 || bytes.IndexByte(fields[4], ':') == -1 { return false }
+
+	return true
+}
+
+// parse_short fills the Session from one line of `get system session list` output.
+// Fields not present in this format are left empty, but all requested structures
+// are created so they can be safely used by the caller.
+func parse_short(data []byte, requested *SessionDataRequest, pe *parseErrors) *Session {
+	var s Session
+	fields := strings.Fields(string(data))
+
+	if requested.Plain { s.Plain = strings.TrimSpace(string(data)) }
+
+	if requested.Basics {
+		s.Basics = &Basics{}
+
+		proto, known := short_protocols[strings.ToLower(fields[0])]
+		if !known { proto = uint16(pe.uint(fields[0], 10, 16, "Basics", "proto")) }
+		s.Basics.Protocol = proto
+		s.Basics.Expire   = pe.uint(fields[1], 10, 64, "Basics", "expire")
+	}
+
+	src := split_ip_port(fields[2], pe)
+	dst := split_ip_port(fields[4], pe)
+	s.Ipv6 = src.Ip != nil && src.Ip.To4() == nil
+
+	if requested.Hooks {
+		// the same hooks as in `diagnose sys session list` for the original direction,
+		// destination NAT hook is first, because it happens in the "pre" routing hook
+		s.Hooks = make([]Hook, 0)
+		if fields[5] != "-" {
+			s.Hooks = append(s.Hooks, Hook{ Hook: "pre", Dir: "org", Act: "dnat", Src: src, Dst: dst, Nat: split_ip_port(fields[5], pe) })
+		}
+		if fields[3] != "-" {
+			s.Hooks = append(s.Hooks, Hook{ Hook: "post", Dir: "org", Act: "snat", Src: src, Dst: dst, Nat: split_ip_port(fields[3], pe) })
+		}
+		if len(s.Hooks) == 0 {
+			var nat IpPort
+			if s.Ipv6 { nat.Ip = net.IPv6zero } else { nat.Ip = net.IPv4zero }
+			s.Hooks = append(s.Hooks, Hook{ Hook: "pre", Dir: "org", Act: "noop", Src: src, Dst: dst, Nat: nat })
+		}
+	}
+
+	fill_empty(&s, requested)
+	return &s
+}
+
+// fill_empty creates all requested structures that were not filled yet,
+// it is used for formats that do not contain all the fields
+func fill_empty(s *Session, requested *SessionDataRequest) {
+	if requested.Hooks      && s.Hooks      == nil { s.Hooks      = make([]Hook, 0)  }
+	if requested.States     && s.States     == nil { s.States     = make([]State, 0) }
+	if requested.Basics     && s.Basics     == nil { s.Basics     = &Basics{}        }
+	if requested.Stats      && s.Stats      == nil { s.Stats      = &Stats{}         }
+	if requested.Rate       && s.Rate       == nil { s.Rate       = &Rate{}          }
+	if requested.Npu        && s.Npu        == nil { s.Npu        = &Npu{}           }
+	if requested.Policy     && s.Policy     == nil { s.Policy     = &Policy{}        }
+	if requested.Other      && s.Other      == nil { s.Other      = &Other{}         }
+	if requested.NpuError   && s.NpuError   == nil { s.NpuError   = &NpuError{}      }
+	if requested.Shaping    && s.Shaping    == nil { s.Shaping    = &Shaping{}       }
+	if requested.Macs       && s.Macs       == nil { s.Macs       = &Macs{}          }
+	if requested.Interfaces && s.Interfaces == nil { s.Interfaces = &Interfaces{}    }
+	if requested.Auth       && s.Auth       == nil { s.Auth       = &Auth{}          }
+	if requested.App        && s.App        == nil { s.App        = &App{}           }
+	if requested.Sdwan      && s.Sdwan      == nil { s.Sdwan      = &Sdwan{}         }
+	if requested.NpuSession && s.NpuSession == nil { s.NpuSession = &NpuSession{}    }
+	if requested.Mcast      && s.Mcast      == nil { s.Mcast      = &Mcast{}         }
+	// aux
+	if requested.Custom     && s.Custom     == nil { s.Custom     = make(map[string]*multivalue.MultiValue) }
+	if requested.Raw        && s.Raw        == nil { s.Raw        = make(map[string]string) }
+}
+
+func is_npu_session(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, "\r\n"), []byte("npu-session info:"))
+}
+
+// parse_npu_session fills the Session from one hardware session, which looks like:
+//
+//   npu-session info: npu=0 hash=0x0001a2b3 action=fwd proto=6 duration=120 expire=3480
+//   org=10.1.100.11:49470->172.16.200.55:80(172.16.200.1:49470) pkts=12 bytes=1200
+//   reply=172.16.200.55:80->172.16.200.1:49470(10.1.100.11:49470) pkts=10 bytes=5600
+//
+// Addresses and counters are also saved to the regular Hooks and Stats structures,
+// so the hardware sessions can be filtered and displayed the same way as kernel sessions.
+func parse_npu_session(data []byte, requested *SessionDataRequest, pe *parseErrors) *Session {
+	var s Session
+	var npus NpuSession
+	var basics Basics
+	var stats Stats
+	hooks := make([]Hook, 0)
+
+	npus.Valid = true
+	if requested.Plain { s.Plain = strings.TrimLeft(string(data), "\r\n") }
+	if requested.Raw   { s.Raw   = get_raw(data) }
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if bytes.HasPrefix(line, []byte("npu-session info:")) {
+			line = line[len("npu-session info:"):]
+		}
+
+		var k, v string
+		var ok bool
+		var dir string
+
+		for {
+			k, v, ok = extract_pair(&line, []byte("="), []byte(" "))
+			if !ok { break }
+			k = strings.TrimSpace(k)
+
+			if k == "npu" {
+				npus.Id = uint32(pe.uint(v, 10, 32, "NpuSession", k))
+			} else if k == "hash" {
+				npus.Hash = uint32(pe.uint(strings.TrimPrefix(v, "0x"), 16, 32, "NpuSession", k))
+			} else if k == "action" {
+				npus.Action = v
+			} else if k == "proto" {
+				basics.Protocol = uint16(pe.uint(v, 10, 16, "Basics", k))
+			} else if k == "duration" {
+				basics.Duration = pe.uint(v, 10, 64, "Basics", k)
+			} else if k == "expire" {
+				basics.Expire = pe.uint(v, 10, 64, "Basics", k)
+			} else if k == "org" || k == "reply" {
+				dir = k
+				hooks = append(hooks, npu_session_hook(dir, v, pe))
+			} else if k == "pkts" && dir == "org" {
+				npus.Packets_org = pe.uint(v, 10, 64, "NpuSession", k)
+			} else if k == "pkts" && dir == "reply" {
+				npus.Packets_rev = pe.uint(v, 10, 64, "NpuSession", k)
+			} else if k == "bytes" && dir == "org" {
+				npus.Bytes_org = pe.uint(v, 10, 64, "NpuSession", k)
+			} else if k == "bytes" && dir == "reply" {
+				npus.Bytes_rev = pe.uint(v, 10, 64, "NpuSession", k)
+			}
+		}
+	}
+
+	if len(hooks) == 0 { pe.missing("Hooks", "org") }
+	if len(hooks) > 0 && hooks[0].Src.Ip != nil { s.Ipv6 = hooks[0].Src.Ip.To4() == nil }
+
+	stats.Packets_org = npus.Packets_org
+	stats.Packets_rev = npus.Packets_rev
+	stats.Bytes_org   = npus.Bytes_org
+	stats.Bytes_rev   = npus.Bytes_rev
+	stats.Valid_org   = true
+	stats.Valid_rev   = true
+
+	if requested.Hooks      { s.Hooks      = hooks  }
+	if requested.Basics     { s.Basics     = &basics }
+	if requested.Stats      { s.Stats      = &stats  }
+	if requested.NpuSession { s.NpuSession = &npus   }
+
+	fill_empty(&s, requested)
+	return &s
+}
+
+func is_mcast_session(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, "\r\n"), []byte("session info: id="))
+}
+
+// parse_mcast_session fills the Session from one multicast session, which looks like:
+//
+//   session info: id=0 vf=0 proto=17 10.1.1.1.5000->239.1.1.1.5000
+//   used=2 path=2 duration=1 expire=179 indev=5 pkts=2
+//   state:2cpu
+//   path: 0 policy=1, outdev=6
+//   	(ipv4 dport=5000 chk_spi=0, dyn_spi=0, tos=ff/ff)
+//   path: 1 policy=1, outdev=7
+//
+// Source and group are also saved as the source and destination of the original direction hook,
+// VDOM and the first path policy to Policy, incoming and the first outgoing interface to Interfaces
+// and the packet counter to Stats.
+func parse_mcast_session(data []byte, requested *SessionDataRequest, pe *parseErrors) *Session {
+	var s Session
+	var mcast Mcast
+	var basics Basics
+	var stats Stats
+	var policy Policy
+	var ifaces Interfaces
+	var hook Hook
+
+	mcast.Valid   = true
+	mcast.OutDevs = make([]uint32, 0)
+	if requested.Plain { s.Plain = strings.TrimLeft(string(data), "\r\n") }
+	if requested.Raw   { s.Raw   = get_raw(data) }
+
+	policy_found := false
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+
+		if bytes.HasPrefix(line, []byte("session info: ")) {
+			line = line[len("session info: "):]
+
+			// the last field is "source.port->group.port"
+			if sp := bytes.LastIndexByte(line, ' '); sp != -1 && bytes.Contains(line[sp:], []byte("->")) {
+				addrs := strings.Split(string(line[sp+1:]), "->")
+				hook.Src = split_ip_port(mcast_to_colon(addrs[0]), pe)
+				hook.Dst = split_ip_port(mcast_to_colon(addrs[1]), pe)
+				line = line[:sp]
+			} else {
+				pe.missing("Mcast", "source->group")
+			}
+
+		} else if bytes.HasPrefix(line, []byte("path: ")) {
+			line = line[len("path: "):]
+		} else if bytes.HasPrefix(line, []byte("(")) {
+			continue
+		}
+
+		var k, v string
+		var ok bool
+
+		for {
+			k, v, ok = extract_pair(&line, []byte("="), []byte(" "))
+			if !ok { break }
+			v = strings.TrimRight(v, ",")
+			if sp := strings.LastIndex(k, " "); sp != -1 { k = k[sp+1:] }
+
+			if k == "id" {
+				mcast.Id = uint32(pe.uint(v, 10, 32, "Mcast", k))
+			} else if k == "vf" {
+				policy.Vdom = uint32(pe.uint(v, 10, 32, "Policy", k))
+			} else if k == "proto" {
+				basics.Protocol = uint16(pe.uint(v, 10, 16, "Basics", k))
+			} else if k == "duration" {
+				basics.Duration = pe.uint(v, 10, 64, "Basics", k)
+			} else if k == "expire" {
+				basics.Expire = pe.uint(v, 10, 64, "Basics", k)
+			} else if k == "indev" {
+				mcast.InDev = uint32(pe.uint(v, 10, 32, "Mcast", k))
+			} else if k == "pkts" {
+				mcast.Packets = pe.uint(v, 10, 64, "Mcast", k)
+			} else if k == "outdev" {
+				mcast.OutDevs = append(mcast.OutDevs, uint32(pe.uint(v, 10, 32, "Mcast", k)))
+			} else if k == "policy" && !policy_found {
+				policy.Id = uint32(pe.uint(v, 10, 32, "Policy", k))
+				policy_found = true
+			}
+		}
+	}
+
+	mcast.Source = hook.Src.Ip
+	mcast.Group  = hook.Dst.Ip
+	s.Ipv6       = mcast.Source != nil && mcast.Source.To4() == nil
+
+	hook.Hook = "pre"
+	hook.Dir  = "org"
+	hook.Act  = "noop"
+	if s.Ipv6 { hook.Nat.Ip = net.IPv6zero } else { hook.Nat.Ip = net.IPv4zero }
+
+	ifaces.In_org = mcast.InDev
+	if len(mcast.OutDevs) > 0 { ifaces.Out_org = mcast.OutDevs[0] }
+
+	stats.Packets_org = mcast.Packets
+	stats.Valid_org   = true
+
+	if requested.Hooks      { s.Hooks      = []Hook{ hook } }
+	if requested.Basics     { s.Basics     = &basics }
+	if requested.Stats      { s.Stats      = &stats  }
+	if requested.Policy     { s.Policy     = &policy }
+	if requested.Interfaces { s.Interfaces = &ifaces }
+	if requested.Mcast      { s.Mcast      = &mcast  }
+
+	fill_empty(&s, requested)
+	return &s
+}
+
+// mcast_to_colon converts "1.2.3.4.5000" to "1.2.3.4:5000", IPv6 addresses
+// are already printed with the colon before port
+func mcast_to_colon(s string) string {
+	if strings.Count(s, ".") != 4 { return s }
+	dot := strings.LastIndex(s, ".")
+	return s[:dot] + ":" + s[dot+1:]
+}
+
+// npu_session_hook converts the "src:port->dst:port(nat:port)" to the same hook
+// as in the kernel session: source NAT in original and destination NAT in reply direction
+func npu_session_hook(dir string, addrs string, pe *parseErrors) Hook {
+	var hook Hook
+	hook.Dir = dir
+	if dir == "org" { hook.Hook = "post" } else { hook.Hook = "pre" }
+	hook.Act = "noop"
+
+	arrow := strings.Index(addrs, "->")
+	if arrow == -1 {
+		pe.add("Hooks", dir, addrs, "expected \"src:port->dst:port(nat:port)\" format")
+		return hook
+	}
+
+	paren := strings.LastIndex(addrs, "(")
+	if paren == -1 || paren < arrow {
+		hook.Src = split_ip_port(addrs[:arrow], pe)
+		hook.Dst = split_ip_port(addrs[arrow+2:], pe)
+		if hook.Src.Ip != nil && hook.Src.Ip.To4() == nil { hook.Nat.Ip = net.IPv6zero } else { hook.Nat.Ip = net.IPv4zero }
+		return hook
+	}
+
+	hook.Src = split_ip_port(addrs[:arrow], pe)
+	hook.Dst = split_ip_port(addrs[arrow+2:paren], pe)
+	hook.Nat = split_ip_port(strings.TrimRight(addrs[paren+1:], ") "), pe)
+	if hook.Nat.Ip != nil && !hook.Nat.Ip.IsUnspecified() {
+		if dir == "org" { hook.Act = "snat" } else { hook.Act = "dnat" }
+	}
+
+	return hook
+}
+
+// consumed_keys are the fields that are already parsed to the specific
+// Session structures, those are not saved in the Raw map
+var consumed_keys = map[string]bool {
+	"proto": true, "proto_state": true, "duration": true, "expire": true, "timeout": true,
+	"serial": true, "app_list": true, "app": true, "url_cat": true,
+	"sdwan_mbr_seq": true, "sdwan_service_id": true, "rpdb_link_id": true,
+	"ha_id": true, "helper": true, "shaping_policy_id": true, "tunnel": true, "state": true,
+	"user": true, "auth_server": true, "auth_info": true,
+	"flag": true, "offload": true, "ips_offload": true, "in_npu": true, "out_npu": true,
+	"hook": true, "dir": true, "act": true, "org": true, "reply": true,
+	"policy_id": true, "vd": true, "origin-shaper": true, "reply-shaper": true, "per_ip_shaper": true,
+	"src_mac": true, "dst_mac": true, "dev": true, "gwy": true,
+}
+
+// get_raw collects all "key=value" pairs that are not parsed by other functions.
+// If the same key appears more times, only the first value is saved.
+func get_raw(data []byte) map[string]string {
+	raw := make(map[string]string)
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		for _, field := range strings.Fields(string(line)) {
+			eq := strings.Index(field, "=")
+			if eq <= 0 { continue }
+
+			key   := field[:eq]
+			value := strings.TrimRight(field[eq+1:], ",")
+			if consumed_keys[key] { continue }
+			if _, exists := raw[key]; exists { continue }
+
+			raw[key] = value
+		}
+	}
+
+	return raw
+}
+
+func is_ipv6(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, "\r\n"), []byte("session6 info:"))
+}
+
+func get_serial(data *[]byte, pe *parseErrors) uint64 {
 	lines := extract_lines(data, []byte("serial="))
-	if len(lines) == 0 { return 0 }
+	// IPv6 sessions have serial in the middle of the "policy_id" line
+	if len(lines) == 0 { lines = find_lines_with_field(data, []byte("serial"), nil) }
+	if len(lines) == 0 {
+		pe.missing("Serial", "serial")
+		return 0
+	}
 
 	var k, v string
 	var ok bool
@@ -299,40 +852,100 @@ func get_serial(data *[]byte) uint64 {
 			if !ok { break }
 
 			if k == "serial" {
-				val, _ := strconv.ParseUint(v, 16, 64)
-				return val
+				return pe.uint(v, 16, 64, "Serial", "serial")
 			}
 		}
 	}
 
+	pe.missing("Serial", "serial")
 	return 0
 }
 
-func get_other(data *[]byte) *Other {
+func get_app(data *[]byte, pe *parseErrors) *App {
+	var app App
+	var k, v string
+	var ok bool
+
+	for _, line := range find_lines_with_field(data, []byte("app_list"), nil) {
+		for {
+			k, v, ok = extract_pair(&line, []byte("="), []byte(" "))
+			if !ok { break }
+
+			if k == "app_list" {
+				app.List = uint32(pe.uint(v, 10, 32, "App", k))
+			} else if k == "app" {
+				app.Id = uint32(pe.uint(v, 10, 32, "App", k))
+			} else if k == "url_cat" {
+				app.UrlCat = uint32(pe.uint(v, 10, 32, "App", k))
+			}
+		}
+	}
+
+	return &app
+}
+
+func get_sdwan(data *[]byte, pe *parseErrors) *Sdwan {
+	var sdwan Sdwan
+	var k, v string
+	var ok bool
+
+	var lines [][]byte
+	lines = append(lines, find_lines_with_field(data, []byte("sdwan_mbr_seq"), nil)...)
+	lines = append(lines, find_lines_with_field(data, []byte("rpdb_link_id"), nil)...)
+
+	for _, line := range lines {
+		for {
+			k, v, ok = extract_pair(&line, []byte("="), []byte(" "))
+			if !ok { break }
+
+			// some versions print "rpdb_link_id= 80000003" with the space after "="
+			if len(v) == 0 && k == "rpdb_link_id" {
+				line = bytes.TrimLeft(line, " ")
+				end := bytes.IndexByte(line, ' ')
+				if end == -1 { end = len(line) }
+				v, line = string(line[:end]), line[end:]
+			}
+
+			if k == "sdwan_mbr_seq" {
+				sdwan.MemberSeq = uint32(pe.uint(v, 10, 32, "Sdwan", k))
+			} else if k == "sdwan_service_id" {
+				sdwan.ServiceId = uint32(pe.uint(v, 10, 32, "Sdwan", k))
+			} else if k == "rpdb_link_id" {
+				sdwan.RpdbLinkId = uint32(pe.uint(v, 16, 32, "Sdwan", k))
+			}
+		}
+	}
+
+	return &sdwan
+}
+
+func get_other(data *[]byte, pe *parseErrors) *Other {
 	var other Other
 	var k, v string
 	var ok bool
 
-	for _, line := range find_lines_with_field(data, []byte("ha_id"), nil) {
+	lines := find_lines_with_field(data, []byte("ha_id"), nil)
+	if len(lines) == 0 { pe.missing("Other", "ha_id") }
+
+	for _, line := range lines {
 		for {
 			k, v, ok = extract_pair(&line, []byte("="), []byte(" "))
 			if !ok { break }
 
 			if k == "ha_id" {
-				tmp, _ := strconv.ParseUint(v, 10, 8)
-				other.HAid = uint8(tmp)
+				other.HAid = uint8(pe.uint(v, 10, 8, "Other", k))
 
 			} else if k == "helper" {
 				other.Helper = v
 
 			} else if k == "shaping_policy_id" {
-				tmp, _ := strconv.ParseUint(v, 10, 32)
-				other.ShapingPolicyId = uint32(tmp)
+				other.ShapingPolicyId = uint32(pe.uint(v, 10, 32, "Other", k))
 
 			} else if k == "tunnel" {
 				tmp := strings.Split(v, "/")
 				if len(tmp) != 2 {
 					log.Debugf("Cannot parse tunnel in \"%s\"", line)
+					pe.add("Other", k, v, "expected \"out/in\" format")
 					continue
 				}
 				other.Tunnel_in = tmp[1]
@@ -363,7 +976,7 @@ func get_states(data *[]byte) []State {
 	return states
 }
 
-func get_auth(data *[]byte) *Auth {
+func get_auth(data *[]byte, pe *parseErrors) *Auth {
 	var auth    Auth
 	var lines   [][]byte
 
@@ -383,6 +996,7 @@ func get_auth(data *[]byte) *Auth {
 				num, err := strconv.ParseUint(v, 10, 64)
 				if err != nil {
 					log.Warningf("Unable to parse \"auth_info\" data \"%s\": %s", v, err)
+					pe.check(err, "Auth", k, v)
 				}
 				auth.AuthInfo = uint64(num)
 			}
@@ -392,12 +1006,23 @@ func get_auth(data *[]byte) *Auth {
 	return &auth
 }
 
-func get_npu(data *[]byte) *Npu {
+func get_npu(data *[]byte, pe *parseErrors) *Npu {
 	var npu Npu
 
 	var k, v string
 	var ok bool
 
+	// returns exactly two numbers (original and reverse direction) from "x/y" value
+	pair := func(field string, value string) []uint64 {
+		tmp, err := slash_numbers(value)
+		pe.check(err, "Npu", field, value)
+		if len(tmp) != 2 {
+			pe.add("Npu", field, value, "expected two numbers")
+			tmp = append(tmp, 0, 0)
+		}
+		return tmp
+	}
+
 	lines := extract_lines(data, []byte("npu info:"))
 	for _, line := range lines {
 		line = line[10:]
@@ -407,15 +1032,15 @@ func get_npu(data *[]byte) *Npu {
 			if !ok { break }
 
 			if k == "offload" {
-				tmp := slash_numbers(v)
+				tmp := pair(k, v)
 				npu.Offload_org = uint8(tmp[0])
 				npu.Offload_rev = uint8(tmp[1])
 			} else if k == "ips_offload" {
-				tmp := slash_numbers(v)
+				tmp := pair(k, v)
 				npu.Nturbo_org = uint8(tmp[0])
 				npu.Nturbo_rev = uint8(tmp[1])
 			} else if k == "flag" {
-				tmp := slash_numbers(v)
+				tmp := pair(k, v)
 				npu.Flag_org = uint8(tmp[0])
 				npu.Flag_rev = uint8(tmp[1])
 			}
@@ -428,7 +1053,7 @@ func get_npu(data *[]byte) *Npu {
 			if !ok { break }
 
 			if k == "in_npu" {
-				tmp := slash_numbers(v)
+				tmp := pair(k, strings.TrimRight(v, ","))
 				if tmp[0] == 0 {
 					npu.InNpu_org_valid = false
 				} else {
@@ -442,7 +1067,7 @@ func get_npu(data *[]byte) *Npu {
 					npu.InNpu_fwd_valid = true
 				}
 			} else if k == "out_npu" {
-				tmp := slash_numbers(v)
+				tmp := pair(k, strings.TrimRight(v, ","))
 				if tmp[0] == 0 {
 					npu.OutNpu_org_valid = false
 				} else {
@@ -493,10 +1118,12 @@ func get_npu_error(data *[]byte) *NpuError {
 	return &npue
 }
 
-func get_hooks(data *[]byte) []Hook {
+func get_hooks(data *[]byte, pe *parseErrors) []Hook {
 	hooks := make([]Hook, 0)
 
 	lines := extract_lines(data, []byte("hook="))
+	if len(lines) == 0 { pe.missing("Hooks", "hook") }
+
 	for _, line := range lines {
 		var hook Hook
 		var k, v string
@@ -511,19 +1138,21 @@ func get_hooks(data *[]byte) []Hook {
 			if k == "act"  { hook.Act  = v }
 		}
 
-		var port uint64
-
-		k, v, ok = extract_pair(&line, []byte(":"), []byte("->"))
-		port, _ = strconv.ParseUint(v, 10, 16)
-		hook.Src = IpPort { Ip : net.ParseIP(k), Port : uint16(port) }
-
-		k, v, ok = extract_pair(&line, []byte(":"), []byte("("))
-		port, _ = strconv.ParseUint(v, 10, 16)
-		hook.Dst = IpPort { Ip : net.ParseIP(k), Port : uint16(port) }
+		// the rest of the line is "src:port->dst:port(nat:port)",
+		// IPv6 addresses contain ":" as well, so port is always after the last one
+		addrs := string(line)
+		arrow := strings.Index(addrs, "->")
+		paren := strings.LastIndex(addrs, "(")
+		if arrow == -1 || paren == -1 || paren < arrow {
+			log.Debugf("Cannot parse addresses in hook \"%s\"", addrs)
+			pe.add("Hooks", "hook", addrs, "expected \"src:port->dst:port(nat:port)\" format")
+			hooks = append(hooks, hook)
+			continue
+		}
 
-		k, v, ok = extract_pair(&line, []byte(":"), []byte(")"))
-		port, _ = strconv.ParseUint(v, 10, 16)
-		hook.Nat = IpPort { Ip : net.ParseIP(k), Port : uint16(port) }
+		hook.Src = split_ip_port(addrs[:arrow], pe)
+		hook.Dst = split_ip_port(addrs[arrow+2:paren], pe)
+		hook.Nat = split_ip_port(strings.TrimRight(addrs[paren+1:], ") "), pe)
 
 		hooks = append(hooks, hook)
 	}
@@ -531,12 +1160,15 @@ func get_hooks(data *[]byte) []Hook {
 	return hooks
 }
 
-func get_basics(data *[]byte) (*Basics) {
+func get_basics(data *[]byte, pe *parseErrors) (*Basics) {
 	var basics Basics
 
 	lines := extract_lines(data, []byte("session info: "))
+	lines  = append(lines, extract_lines(data, []byte("session6 info: "))...)
+	if len(lines) == 0 { pe.missing("Basics", "session info") }
+
 	for _, line := range lines {
-		line = line[len("session info: "):]
+		line = line[bytes.Index(line, []byte(": "))+2:]
 
 		var k, v string
 		var ok bool
@@ -546,22 +1178,20 @@ func get_basics(data *[]byte) (*Basics) {
 			if !ok { break }
 
 			if k == "proto" {
-				tmp, _ := strconv.ParseUint(v, 10, 16)
-				basics.Protocol = uint16(tmp)
+				basics.Protocol = uint16(pe.uint(v, 10, 16, "Basics", k))
 			} else if k == "proto_state" {
-				tmp1, _ := strconv.ParseUint(string(v[0]), 16, 8)
-				tmp2, _ := strconv.ParseUint(string(v[1]), 16, 8)
-				basics.StateL = uint8(tmp1)
-				basics.StateR = uint8(tmp2)
+				if len(v) != 2 {
+					pe.add("Basics", k, v, "expected two hexadecimal digits")
+					continue
+				}
+				basics.StateL = uint8(pe.uint(string(v[0]), 16, 8, "Basics", k))
+				basics.StateR = uint8(pe.uint(string(v[1]), 16, 8, "Basics", k))
 			} else if k == "duration" {
-				tmp, _ := strconv.ParseUint(v, 10, 64)
-				basics.Duration = uint64(tmp)
+				basics.Duration = pe.uint(v, 10, 64, "Basics", k)
 			} else if k == "expire" {
-				tmp, _ := strconv.ParseUint(v, 10, 64)
-				basics.Expire = uint64(tmp)
+				basics.Expire = pe.uint(v, 10, 64, "Basics", k)
 			} else if k == "timeout" {
-				tmp, _ := strconv.ParseUint(v, 10, 64)
-				basics.Timeout = uint64(tmp)
+				basics.Timeout = pe.uint(v, 10, 64, "Basics", k)
 			}
 		}
 	}
@@ -569,10 +1199,12 @@ func get_basics(data *[]byte) (*Basics) {
 	return &basics
 }
 
-func get_rate(data *[]byte) (*Rate) {
+func get_rate(data *[]byte, pe *parseErrors) (*Rate) {
 	var rate Rate
 
 	lines := extract_lines(data, []byte("tx speed(Bps/kbps):"))
+	if len(lines) == 0 { pe.missing("Rate", "tx speed(Bps/kbps)") }
+
 	for _, line := range lines {
 		var k, v string
 		var ok bool
@@ -583,7 +1215,7 @@ func get_rate(data *[]byte) (*Rate) {
 
 			tmp1     := []byte(v)
 			B, _, _  := extract_pair(&tmp1, []byte("/"), []byte(" "))
-			tmp2, _  := strconv.ParseUint(B, 10, 64)
+			tmp2     := pe.uint(B, 10, 64, "Rate", k)
 
 			if k == "tx speed(Bps/kbps)" {
 				rate.Tx_Bps = tmp2
@@ -596,18 +1228,26 @@ func get_rate(data *[]byte) (*Rate) {
 	return &rate
 }
 
-func get_stats(data *[]byte) (*Stats) {
+func get_stats(data *[]byte, pe *parseErrors) (*Stats) {
 	var stats Stats
 
 	lines := extract_lines(data, []byte("statistic(bytes/packets/allow_err): "))
+	if len(lines) == 0 { pe.missing("Stats", "statistic(bytes/packets/allow_err)") }
+
 	for _, line := range lines {
 		line = line[len("statistic(bytes/packets/allow_err): "):]
 
 		for {
 			k, v, ok := extract_pair(&line, []byte("="), []byte(" "))
 			if !ok { break }
-			nums := slash_numbers(v)
-			if len(nums) != 3 { continue }
+			if k != "org" && k != "reply" { continue }
+
+			nums, err := slash_numbers(v)
+			pe.check(err, "Stats", k, v)
+			if len(nums) != 3 {
+				pe.add("Stats", k, v, "expected three numbers")
+				continue
+			}
 
 			if k == "org" {
 				stats.Bytes_org   = nums[0]
@@ -620,9 +1260,6 @@ func get_stats(data *[]byte) (*Stats) {
 				stats.Packets_rev = nums[1]
 				stats.Errors_rev  = nums[2]
 				stats.Valid_rev   = true
-
-			} else {
-				continue
 			}
 		}
 	}
@@ -630,22 +1267,23 @@ func get_stats(data *[]byte) (*Stats) {
 	return &stats
 }
 
-func get_policy(data *[]byte) *Policy {
+func get_policy(data *[]byte, pe *parseErrors) *Policy {
 	var policy Policy
 	var k, v string
 	var ok bool
 
-	for _, line := range find_lines_with_field(data, []byte("policy_id"), nil) {
+	lines := find_lines_with_field(data, []byte("policy_id"), nil)
+	if len(lines) == 0 { pe.missing("Policy", "policy_id") }
+
+	for _, line := range lines {
 		for {
 			k, v, ok = extract_pair(&line, []byte("="), []byte(" "))
 			if !ok { break }
 
 			if k == "policy_id" {
-				tmp, _ := strconv.ParseUint(v, 10, 64)
-				policy.Id = uint32(tmp)
+				policy.Id = uint32(pe.uint(v, 10, 32, "Policy", k))
 			} else if k == "vd" {
-				tmp, _ := strconv.ParseUint(v, 10, 64)
-				policy.Vdom = uint32(tmp)
+				policy.Vdom = uint32(pe.uint(v, 10, 32, "Policy", k))
 			}
 		}
 	}
@@ -706,12 +1344,15 @@ func get_macs(data *[]byte) *Macs{
 	return &macs
 }
 
-func get_interfaces(data *[]byte) *Interfaces {
+func get_interfaces(data *[]byte, pe *parseErrors) *Interfaces {
 	var ifaces Interfaces
 	var k, v string
 	var ok bool
 
-	for _, line := range find_lines_with_field(data, []byte("dev"), nil) {
+	lines := find_lines_with_field(data, []byte("dev"), nil)
+	if len(lines) == 0 { pe.missing("Interfaces", "dev") }
+
+	for _, line := range lines {
 		d := bytes.Index(line, []byte("dev="))
 		line = line[d:]
 
@@ -725,6 +1366,7 @@ func get_interfaces(data *[]byte) *Interfaces {
 						num, err := strconv.ParseUint(io, 10, 32)
 						if err != nil {
 							log.Warningf("Cannot extract numbers from dev field \"%s\": %s", v, err)
+							pe.check(err, "Interfaces", k, v)
 							break
 						}
 
@@ -734,6 +1376,7 @@ func get_interfaces(data *[]byte) *Interfaces {
 						} else if i == 1 && ii == 1 { ifaces.Out_rev  = uint32(num)
 						} else {
 							log.Warningf("Invalid format of dev field \"%s\"", v)
+							pe.add("Interfaces", k, v, "expected \"in->out/in->out\" format")
 							break
 						}
 					}
@@ -744,8 +1387,10 @@ func get_interfaces(data *[]byte) *Interfaces {
 					} else if i == 1 { ifaces.NextHop_rev = net.ParseIP(nh)
 					} else {
 						log.Warningf("Invalid format of gwy field \"%s\"", v)
+						pe.add("Interfaces", k, v, "expected \"ip/ip\" format")
 						break
 					}
+					if net.ParseIP(nh) == nil { pe.add("Interfaces", k, v, "invalid IP address") }
 				}
 			}
 		}
@@ -812,7 +1457,31 @@ func extract_pair(line *[]byte, equals []byte, term []byte) (string, string, boo
 	return key, value, true
 }
 
-func slash_numbers(s string) []uint64 {
+func split_ip_port(s string, pe *parseErrors) IpPort {
+	var ipport IpPort
+
+	colon := strings.LastIndex(s, ":")
+	if colon == -1 {
+		pe.add("Hooks", "hook", s, "expected \"ip:port\" format")
+		return ipport
+	}
+
+	port, err := strconv.ParseUint(s[colon+1:], 10, 16)
+	if err != nil {
+		log.Debugf("Cannot parse port in \"%s\": %s", s, err)
+		pe.check(err, "Hooks", "hook", s)
+	}
+
+	ipport.Ip   = net.ParseIP(s[:colon])
+	if ipport.Ip == nil { pe.add("Hooks", "hook", s, "invalid IP address") }
+	ipport.Port = uint16(port)
+	return ipport
+}
+
+// slash_numbers returns all numbers from "x/y/z" format, the first error
+// is returned but the unparsable numbers are still present as zeroes
+func slash_numbers(s string) ([]uint64, error) {
+	var first error
 	nums := make([]uint64, 0)
 
 	for _, part := range strings.Split(s, "/") {
@@ -828,11 +1497,12 @@ func slash_numbers(s string) []uint64 {
 		num, err := strconv.ParseUint(part, base, 64)
 		if err != nil {
 			log.Warningf("Cannot extract numbers from slash format \"%s\": %s", s, err)
+			if first == nil { first = err }
 		}
 		nums = append(nums, num)
 	}
 
-	return nums
+	return nums, first
 }
 
 func find_lines_with_field(data *[]byte, field []byte, offsets *[]int) ([][]byte) {
@@ -6,12 +6,17 @@ package main
 import (
 	"fmt"
 	"io"
+	"os"
+	"io/ioutil"
+	"path/filepath"
 	"bufio"
 	"bytes"
+	"strings"
 	"time"
 	"sync/atomic"
 	"container/list"
 	"compress/gzip"
+	"foset/common"
 	"foset/iproviders/common"
 	"foset/fortisession"
 	"foset/fortisession/safequeue"
@@ -19,10 +24,19 @@ import (
 	"foset/fortisession/forticonditioner"
 )
 
+// Compression forces the decompression method,
+// if none is forced, the compression is detected automatically
 type Compression struct {
 	Gzip bool
 }
 
+// supported formats of the session list
+const (
+	INPUT_AUTO      = "auto"      // decide based on the data
+	INPUT_DIAGNOSE  = "diagnose"  // diagnose sys session list
+	INPUT_SHORT     = "short"     // get system session list
+)
+
 type FileProcessing struct {
 	threads  int
 	done     chan bool
@@ -31,6 +45,14 @@ type FileProcessing struct {
 	progressParams *iprovider_common.WriterParams
 	rd_total uint64
 	rd_match uint64
+	rd_bytes int     // bytes read from the previous input files
+	diagnostics    *ParseDiagnostics
+	snapshot       uint32  // only process this snapshot (0 for all)
+	resume         OpenSessionInputs  // inputs kept open for the next snapshot (nil if not needed)
+	snapshots      uint32  // highest snapshot index seen in the input
+	normalize      bool    // remove terminal capture artifacts
+	benchmark      *Benchmark
+	explainer      *Explainer
 }
 
 
@@ -40,7 +62,20 @@ func (fp *FileProcessing) process_sessions(results chan *fortisession.Session, r
 	for fp.sq.IsActive() {
 		count := 0
 		for _, plain := range fp.sq.Pop(128) {
-			session := fortisession.Parse(plain, req)
+			var session *fortisession.Session
+			var errs    []fortisession.ParseError
+			var start   time.Time
+
+			if fp.benchmark != nil { start = time.Now() }
+			if fp.diagnostics != nil {
+				session, errs = fortisession.ParseStrict(plain.Data, req)
+				fp.diagnostics.Add(errs)
+			} else {
+				session = fortisession.Parse(plain.Data, req)
+			}
+			if fp.benchmark != nil { fp.benchmark.AddParse(time.Now().Sub(start)) }
+			session.Source   = plain.Source
+			session.Snapshot = plain.Snapshot
 
 			// count all parsed sessions
 			atomic.AddUint64(&fp.rd_total, 1)
@@ -48,8 +83,24 @@ func (fp *FileProcessing) process_sessions(results chan *fortisession.Session, r
 				fp.progress.Write([]byte(fmt.Sprintf("SFRS:%d\n", fp.rd_total)))
 			}
 
+			// in strict mode the session that was not parsed correctly is not processed
+			if len(errs) > 0 {
+				for _, e := range errs { log.Errorf("Parse error: %s", e) }
+				continue
+			}
+
 			if run_plugins(plugins, PLUGINS_BEFORE_FILTER, session) { continue }
-			if conditioner != nil && !conditioner.Matches(session) { continue }
+			if conditioner != nil && fp.explainer != nil && fp.explainer.Selected(session) {
+				fp.explainer.Show(session, conditioner)
+			}
+			if conditioner != nil && fp.benchmark != nil {
+				start   = time.Now()
+				matches := conditioner.Matches(session)
+				fp.benchmark.AddFilter(time.Now().Sub(start), matches)
+				if !matches { continue }
+			} else if conditioner != nil && !conditioner.Matches(session) {
+				continue
+			}
 
 			// count sessions matching filter
 			atomic.AddUint64(&fp.rd_match, 1)
@@ -71,26 +122,44 @@ func (fp *FileProcessing) process_sessions(results chan *fortisession.Session, r
 	done <- true
 }
 
+// session_starts contains the first line prefixes of IPv4, IPv6 and hardware (NPU) sessions,
+// the longest one must be the last
+var session_starts = [][]byte{ []byte("\nsession info:"), []byte("\nsession6 info:"), []byte("\nnpu-session info:") }
+
+// find_session_start returns the index of the first session start in data
+// (either IPv4 or IPv6) or -1 if there is none.
+func find_session_start(data []byte) int {
+	first := -1
+	for _, start := range session_starts {
+		i := bytes.Index(data, start)
+		if i != -1 && (first == -1 || i < first) { first = i }
+	}
+	return first
+}
+
 func scanner_split(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	log.Tracef("scanner_split data: %s\n---\n", string(data))
 	// are we at the start of a new session?
 	// if yes (i==0) ok, do the rest
 	// else either ask for bigger buffer (i==-1)
 	// or shift to the start that we have just found (i>0)
-	session_start_data := []byte("\nsession info:")
-	i := bytes.Index(data, session_start_data)
+	longest_start := len(session_starts[len(session_starts)-1])
+	i := find_session_start(data)
 	if i == -1 {
-		if len(session_start_data) > len(data) {
+		// skip only the whole lines, so the text between sessions can be inspected line by line
+		if nl := bytes.LastIndexByte(data, '\n'); nl > 0 {
+			return nl, nil, nil
+		} else if longest_start > len(data) {
 			return 0, nil, nil
 		} else {
-			return len(data)-len(session_start_data), nil, nil
+			return len(data)-longest_start, nil, nil
 		}
 	} else if i > 0  {
 		return i, nil, nil
 	}
 
 	// to find the end of this session data, easiest way it to find the beginning of the next session data
-	n := bytes.Index(data[1:], session_start_data)
+	n := find_session_start(data[1:])
 	if n == -1 {
 		// if we don't have next session info, it can mean two things:
 		// 1) the buffer we are inspecting is not big enough - return 0,nil,nil to signalize it to Scanner
@@ -115,13 +184,53 @@ func scanner_split(data []byte, atEOF bool) (advance int, token []byte, err erro
 	}
 }
 
-func Init_file_processing(results chan *fortisession.Session, req *fortisession.SessionDataRequest, threads int, conditioner *forticonditioner.Condition, plugins []*plugin_common.FosetPlugin, progfilename string) (*FileProcessing) {
+// scanner_split_short returns one session line from the `get system session list` output,
+// all other lines (like the header or the prompt) are skipped.
+func scanner_split_short(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := 0
+	for {
+		n, line, err := bufio.ScanLines(data[start:], atEOF)
+		if err != nil || n == 0 { return start, nil, err }
+
+		if fortisession.IsShortSessionLine(line) {
+			// skipped lines are returned first without token
+			if start > 0 { return start, nil, nil }
+			return n, line, nil
+		}
+		start += n
+	}
+}
+
+// detect_input_format decides which session list format is in the data based on what is found first.
+func detect_input_format(contents []string) string {
+	for _, content := range contents {
+		if content == common.CONTENT_SHORT_SESSION_LIST { return INPUT_SHORT }
+		if content == common.CONTENT_SESSION_LIST || content == common.CONTENT_SESSION6_LIST { return INPUT_DIAGNOSE }
+	}
+	return INPUT_DIAGNOSE
+}
+
+// has_session_list returns true if any of the detected contents is a session list (in any format).
+func has_session_list(contents []string) bool {
+	for _, content := range contents {
+		if content == common.CONTENT_SHORT_SESSION_LIST || content == common.CONTENT_SESSION_LIST || content == common.CONTENT_SESSION6_LIST { return true }
+	}
+	return false
+}
+
+func Init_file_processing(results chan *fortisession.Session, req *fortisession.SessionDataRequest, threads int, conditioner *forticonditioner.Condition, plugins []*plugin_common.FosetPlugin, progfilename string, diagnostics *ParseDiagnostics, benchmark *Benchmark, explainer *Explainer, snapshot uint32, resume OpenSessionInputs, normalize bool) (*FileProcessing) {
 	done := make(chan bool, threads)
 
 	fp := FileProcessing {
 		threads  : threads,
 		done     : done,
 		sq       : safequeue.Init(log.Child("safequeue")),
+		diagnostics : diagnostics,
+		benchmark   : benchmark,
+		explainer   : explainer,
+		snapshot    : snapshot,
+		resume      : resume,
+		normalize   : normalize,
 	}
 
 	// if progress file name is specified, open it
@@ -138,62 +247,182 @@ func Init_file_processing(results chan *fortisession.Session, req *fortisession.
 	return &fp
 }
 
-func (fp *FileProcessing) Read_all_from_file(filename string, compression Compression) (error) {
-	// where to read the data from 
-	var reader  io.Reader
-	var creader *CountingReader
-	var err     error
+// SessionInput is the input file split to sessions. In per-snapshot mode it stays open
+// between the cycles, so the next snapshot continues where the previous one stopped.
+type SessionInput struct {
+	scanner     *bufio.Scanner
+	splitter    *SnapshotSplitter
+	creader     *CountingReader
+	normalizer  *NormalizingReader
+	closers     []func() error
+	pending     *safequeue.Plain  // first session of the next snapshot
+	finished    bool
+}
+
+// OpenSessionInputs keeps the inputs open between the snapshots, indexed by the file name
+type OpenSessionInputs map[string]*SessionInput
+
+// Close closes all the inputs that were not read till the end
+func (open OpenSessionInputs) Close() {
+	for _, in := range open { in.Close() }
+}
+
+func open_session_input(filename string, compression Compression, format string, normalize bool) (*SessionInput, error) {
+	var in SessionInput
 
 	// use input provider
-	reader, _, err = inputs.ProvideReader(filename)
-	if err != nil { return fmt.Errorf("cannot read session data: %s", err) }
-	creader = CountingReaderInit(reader)
+	reader, _, err := inputs.ProvideReader(filename)
+	if err != nil { return nil, fmt.Errorf("cannot read session data: %s", err) }
+	in.closers = append(in.closers, func() error { return common.CloseInput(reader) })
+	in.creader = CountingReaderInit(reader)
 
 	// is the input somehow compressed?
 	if compression.Gzip {
-		tmp, err := gzip.NewReader(creader)
+		tmp, err := gzip.NewReader(in.creader)
 		if err != nil {
-			return fmt.Errorf("Source stream is not gzip compressed: %s", err)
+			in.Close()
+			return nil, fmt.Errorf("Source stream is not gzip compressed: %s", err)
 		}
+		in.closers = append(in.closers, tmp.Close)
 		reader = tmp
 	} else {
-		reader = creader
+		decompressed, detected, err := common.Decompress(in.creader)
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("Source stream cannot be read: %s", err)
+		}
+		in.closers = append(in.closers, decompressed.Close)
+		reader = decompressed
+		log.Debugf("Detected input compression \"%s\"", detected)
+	}
+
+	// remove the terminal capture artifacts before the data is split to sessions
+	if normalize {
+		in.normalizer = InitNormalizingReader(reader)
+		reader = in.normalizer
 	}
 
 	// add new line at the beggining
 	// This is a little workaround because the scanner_split function
-	// expects the string "session info:" to be preceded by a new line.
+	// expects the string "session info:" (or "session6 info:") to be preceded by a new line.
 	// This worked well unless the "session info:" was at the very
 	// beggining of the file. It also didn't work for copy & paste
 	// session on stdin.
 	newline := bytes.NewReader([]byte("\n"))
-	multireader := io.MultiReader(newline, reader)
+	multireader := bufio.NewReaderSize(io.MultiReader(newline, reader), 64*1024)
 
-	scanner := bufio.NewScanner(multireader)
-	scanner.Split(scanner_split)
-	buf := list.New()
+	// find out what command outputs are in the beginning of the data,
+	// in auto mode the session list format is decided by them
+	peek, _ := multireader.Peek(64*1024)
+	contents := common.DetectContent(peek)
+	log.Debugf("Detected input contents: %s", strings.Join(contents, ", "))
+
+	// whole input fits into the peek buffer, so we know for sure there are no sessions
+	if len(peek) < 64*1024 && len(contents) > 0 && !has_session_list(contents) {
+		log.Warningf("Input does not contain any session list (found %s)", strings.Join(contents, ", "))
+	}
+
+	if format == INPUT_AUTO {
+		format = detect_input_format(contents)
+		log.Debugf("Detected input format \"%s\"", format)
+	}
+
+	var splitter *SnapshotSplitter
+	if format == INPUT_SHORT {
+		splitter = InitSnapshotSplitter(scanner_split_short)
+	} else {
+		splitter = InitSnapshotSplitter(scanner_split)
+	}
+	in.scanner = bufio.NewScanner(multireader)
+	in.scanner.Split(splitter.Split)
+	in.splitter = splitter
+
+	return &in, nil
+}
+
+// Close closes the input, it can be called more times
+func (in *SessionInput) Close() {
+	if in.finished { return }
+	in.finished = true
+
+	if in.normalizer != nil && in.normalizer.Corrections.Total() > 0 {
+		log.Infof("Input normalized: %s", in.normalizer.Corrections.String())
+	}
+	for i := len(in.closers)-1; i >= 0; i-- { in.closers[i]() }
+}
+
+func (fp *FileProcessing) Read_all_from_file(filename string, compression Compression, format string) (error) {
+	in, resumed := fp.resume[filename]
+	if !resumed {
+		var err error
+		in, err = open_session_input(filename, compression, format, fp.normalize)
+		if err != nil { return err }
+
+		if fp.resume != nil {
+			fp.resume[filename] = in
+		} else {
+			defer in.Close()
+		}
+	}
+	if in.finished { return nil }
 
 	// read the whole file, split it by session paragraphs and push those to "processing" queue
 	// gorutinesprocess_sessions will paralelly retrive that, convert to Session and push 
 	// "results" channel
+	buf := list.New()
+	start_bytes := in.creader.BytesRead
 	var last_progress int
-	for scanner.Scan() {
-		// save progress if requested
-		if fp.progress != nil && last_progress != creader.BytesRead {
-			fp.progress.Write([]byte(fmt.Sprintf("SFRB:%d\n", creader.BytesRead)))
-			last_progress = creader.BytesRead
+	for {
+		var plain safequeue.Plain
+		if in.pending != nil {
+			plain, in.pending = *in.pending, nil
+		} else {
+			if !in.scanner.Scan() {
+				in.Close()
+				break
+			}
+
+			// save progress if requested
+			if fp.progress != nil && last_progress != in.creader.BytesRead {
+				fp.progress.Write([]byte(fmt.Sprintf("SFRB:%d\n", fp.rd_bytes + in.creader.BytesRead - start_bytes)))
+				last_progress = in.creader.BytesRead
+			}
+
+			session := make([]byte, len(in.scanner.Bytes()))
+			copy(session, in.scanner.Bytes())
+			plain = safequeue.Plain { Data: session, Source: filename, Snapshot: in.splitter.Current() }
+		}
+
+		snapshot := plain.Snapshot
+		if snapshot.Index > fp.snapshots { fp.snapshots = snapshot.Index }
+
+		// only one snapshot is selected, no need to read the rest of the input after it
+		// (in per-snapshot mode the session is kept for the next snapshot)
+		if fp.snapshot > 0 && snapshot.Index > fp.snapshot {
+			if fp.resume != nil { in.pending = &plain }
+			break
 		}
+		if fp.snapshot > 0 && snapshot.Index < fp.snapshot { continue }
 
-		session := make([]byte, len(scanner.Bytes()))
-		copy(session, scanner.Bytes())
-		log.Tracef("Read session:\n%s\n---end---\n", session)
-		buf.PushBack(session)
+		log.Tracef("Read session:\n%s\n---end---\n", plain.Data)
+		buf.PushBack(plain)
 		if buf.Len() >= 1024 {
 			fp.sq.Push(buf)
 			buf = list.New()
 		}
 	}
 	if buf.Len() > 0 { fp.sq.Push(buf) }
+	fp.rd_bytes += in.creader.BytesRead - start_bytes
+
+	return nil
+}
+
+// Finish must be called after all the input files were read,
+// it waits until all the sessions are processed
+func (fp *FileProcessing) Finish() {
+	if fp.snapshot == 0 && fp.snapshots > 1 {
+		log.Infof("Input contains %d session dumps (snapshots), use --snapshot or --per-snapshot to process them separately", fp.snapshots)
+	}
 
 	// Finish will wait for queue to get empty and them will deactivate it
 	fp.sq.Finish()
@@ -205,6 +434,46 @@ func (fp *FileProcessing) Read_all_from_file(filename string, compression Compre
 	if fp.progress != nil && fp.progressParams.Buffered != nil {
 		fp.progressParams.Buffered.Flush()
 	}
+}
 
-	return nil
+// expand_input_files returns the list of input files for the `-r` parameters.
+// Globs are expanded and directories are replaced by the regular files they contain
+// (sorted by name, hidden and cache files are skipped). The standard input and names
+// with input provider schema (like "ssh://") are used as they are.
+func expand_input_files(names []string) ([]string, error) {
+	files := make([]string, 0)
+
+	for _, name := range names {
+		if name == "-" || strings.Contains(name, "://") {
+			files = append(files, name)
+			continue
+		}
+
+		matches := []string{ name }
+		if strings.ContainsAny(name, "*?[") {
+			var err error
+			matches, err = filepath.Glob(name)
+			if err != nil { return nil, fmt.Errorf("invalid pattern \"%s\": %s", name, err) }
+			if len(matches) == 0 { return nil, fmt.Errorf("no file matches \"%s\"", name) }
+		}
+
+		for _, match := range matches {
+			// files that cannot be accessed are left for the input provider to report
+			info, err := os.Stat(match)
+			if err != nil || !info.IsDir() {
+				files = append(files, match)
+				continue
+			}
+
+			entries, err := ioutil.ReadDir(match)
+			if err != nil { return nil, fmt.Errorf("cannot read directory \"%s\": %s", match, err) }
+
+			for _, entry := range entries {
+				if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") || strings.HasSuffix(entry.Name(), ".cache") { continue }
+				files = append(files, filepath.Join(match, entry.Name()))
+			}
+		}
+	}
+
+	return files, nil
 }
@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"foset/plugins/common"
 	"foset/fortisession"
+	"foset/fortisession/fortifields"
 	"foset/fortisession/fortiformatter"
 	"foset/fortisession/forticonditioner"
 	"foset/iproviders"
@@ -29,11 +30,15 @@ func main() {
 	// read arguments
 	parser := argparse.NewParser("foset", "Parses the FortiOS session list. Written by Ondrej Holecek <oholecek@fortinet.com>.")
 	version    := parser.Flag(  "v", "version",  &argparse.Options{Default: false,            Help: "Print current version"})
-	sessionfile:= parser.String("r", "file",     &argparse.Options{Default: "",               Help: "File containing the session list, use \"-\" for stdin"})
+	sessionfile:= parser.List(  "r", "file",     &argparse.Options{                           Help: "File containing the session list, use \"-\" for stdin (can be repeated, globs and directories are expanded)"})
 	output     := parser.String("o", "output",   &argparse.Options{Default: "${default_basic}", Help: "Format of the output"})
+	outformat  := parser.Selector("", "format", []string{OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_NDJSON}, &argparse.Options{Default: OUTPUT_TEXT, Help: "Print sessions using the output format string (text) or as JSON objects with parts requested by output format, filter and plugins"})
+	csv_cols   := parser.String("", "csv",      &argparse.Options{Default: "",               Help: "Print sessions as CSV with comma separated output variables as columns (like \"serial,sa,dp,custom|vdom\")"})
+	tsv_cols   := parser.String("", "tsv",      &argparse.Options{Default: "",               Help: "Print sessions as TSV with comma separated output variables as columns"})
 	filter     := parser.String("f", "filter",   &argparse.Options{Default: "",               Help: "Show only sessions matching filter"})
 	debug      := parser.Flag(  "d", "debug",    &argparse.Options{Default: false,            Help: "Print also debugging outputs"})
-	gzip_in    := parser.Flag(  "g", "gzip",     &argparse.Options{Default: false,            Help: "Enable if input is gzip compressed"})
+	gzip_in    := parser.Flag(  "g", "gzip",     &argparse.Options{Default: false,            Help: "Force gzip decompression (compression is detected automatically)"})
+	informat   := parser.Selector("", "input-format", []string{INPUT_AUTO, INPUT_DIAGNOSE, INPUT_SHORT}, &argparse.Options{Default: INPUT_AUTO, Help: "Format of the session list"})
 	cache_save := parser.Flag(  "s", "save",     &argparse.Options{Default: false,            Help: "Only save parsed data to cache file [EXPERIMENTAL]"})
 	cache_read := parser.Flag(  "c", "cache",    &argparse.Options{Default: false,            Help: "Load session data from cached file [EXPERIMENTAL]"})
 	plugin_ext := parser.List(  "P", "external-plugin", &argparse.Options{                    Help: "Load external plugin library"})
@@ -47,6 +52,17 @@ func main() {
 	parse_all  := parser.Flag(  "", "parse-all", &argparse.Options{Default: false,            Help: "Debugging: parse all fields regardless on filter and output"})
 	outfile    := parser.String(  "", "output-file",   &argparse.Options{Default: "-",           Help: "Where to write the output, \"-\" for stdout"})
 	progfile   := parser.String(  "", "progress-file", &argparse.Options{Default: "",            Help: "Where to write the parsing progress data"})
+	snapshot   := parser.Int(   "", "snapshot", &argparse.Options{Default: 0,                Help: "Only process sessions from this session dump in the file (starting from 1)"})
+	per_snap   := parser.Flag(  "", "per-snapshot", &argparse.Options{Default: false,        Help: "Process each session dump in the file separately"})
+	per_source := parser.Flag(  "", "per-source", &argparse.Options{Default: false,          Help: "Process each input file separately"})
+	no_normal  := parser.Flag(  "", "no-normalize", &argparse.Options{Default: false,        Help: "Do not remove terminal capture artifacts (pagers, escape sequences, wrapped lines) from input"})
+	strict     := parser.Flag(  "", "strict",    &argparse.Options{Default: false,            Help: "Report sessions with unparsable or missing fields and skip them"})
+	benchmark  := parser.Flag(  "", "benchmark", &argparse.Options{Default: false,            Help: "Show time spent in parsing and filtering the sessions"})
+	explain    := parser.String(  "", "explain", &argparse.Options{Default: "",               Help: "Show how the filter is evaluated for the first N sessions or for \"serial=<hex>,...\" sessions"})
+	services   := parser.List(  "", "services",  &argparse.Options{                           Help: "Load service names used in filter from file (\"/etc/services\" format or FortiOS custom services config)"})
+	list_prof  := parser.Flag(  "", "list-profiles", &argparse.Options{Default: false,       Help: "List filter and output profiles from profiles file and exit"})
+	list_field := parser.Flag(  "", "fields",    &argparse.Options{Default: false,            Help: "List all fields usable in filter and output format and exit"})
+	chk_filter := parser.Flag(  "", "check-filter", &argparse.Options{Default: false,        Help: "Only check the filter syntax and exit (no input file is needed)"})
 	profiler   := parser.String(  "", "profiler",&argparse.Options{Default: "",               Help: "Debugging: enable profiler (mem or cpu)"})
 	if err := parser.Parse(os.Args); err != nil {
 		fmt.Println(err)
@@ -67,11 +83,36 @@ func main() {
 		os.Exit(0)
 	}
 
-	if *sessionfile == "" {
+	if (*list_field) {
+		fmt.Print(fortifields.List())
+		os.Exit(0)
+	}
+
+	if len(*sessionfile) == 0 && !*chk_filter && !*list_prof {
 		fmt.Println("File parameter required\nUse -h for help")
 		os.Exit(1)
 	}
 
+	if (len(*csv_cols) > 0 || len(*tsv_cols) > 0) && *outformat != OUTPUT_TEXT {
+		fmt.Println("CSV or TSV output cannot be combined with JSON format")
+		os.Exit(1)
+	}
+	if len(*csv_cols) > 0 && len(*tsv_cols) > 0 {
+		fmt.Println("CSV and TSV output cannot be used together")
+		os.Exit(1)
+	}
+
+	sessionfiles, err := expand_input_files(*sessionfile)
+	if err != nil {
+		fmt.Printf("Cannot find input files: %s\n", err)
+		os.Exit(1)
+	}
+	// directories can be empty or contain only skipped files
+	if len(sessionfiles) == 0 && !*chk_filter && !*list_prof {
+		fmt.Println("No input files found")
+		os.Exit(1)
+	}
+
 	if *profiler == "cpu" {
 		defer profile.Start().Stop()
 	} else if *profiler == "mem" {
@@ -93,8 +134,41 @@ func main() {
 	fortiformatter.InitLog(log.Child("formatter"))
 	fortisession.InitLog(log.Child("session"))
 
+	for _, filename := range *services {
+		if err := forticonditioner.LoadServices(filename); err != nil {
+			log.Criticalf("Cannot load services: %s", err)
+			os.Exit(100)
+		}
+	}
+
+	// filter and output can refer to profiles
+	profiles, err := load_profiles()
+	if err != nil {
+		log.Criticalf("Cannot load profiles: %s", err)
+		os.Exit(100)
+	}
+	if (*list_prof) {
+		fmt.Print(profiles.List())
+		os.Exit(0)
+	}
+	if *filter, err = profiles.ExpandFilter(*filter); err != nil {
+		if (*chk_filter) {
+			fmt.Printf("Filter error: %s\n", err)
+			os.Exit(1)
+		}
+		log.Criticalf("Cannot expand filter: %s", err)
+		os.Exit(100)
+	}
+	if *output, err = profiles.ExpandOutput(*output); err != nil {
+		log.Criticalf("Cannot expand output format: %s", err)
+		os.Exit(100)
+	}
+
+	if (*chk_filter) {
+		check_filter(*filter)
+	}
+
 	// input providers
-	var err error
 	inputs, err = iproviders.Init(*ipparams, log.Child("iproviders"))
 	if err != nil {
 		log.Criticalf("cannot initialize providers: %s", err)
@@ -111,7 +185,8 @@ func main() {
 	for _, p := range *plugin_ext {
 		log.Debugf("Loading external plugin \"%s\"", p)
 		pinfo := plugin_common.FosetPlugin{
-			Filename : *sessionfile,
+			Filename : sessionfiles[0],
+			Filenames: sessionfiles,
 			Filter   : *filter,
 			Version  : mainVersion,
 			Commit   : fosetGitCommit,
@@ -130,7 +205,8 @@ func main() {
 	for _, p := range *plugin_int {
 		log.Debugf("Loading internal plugin \"%s\"", p)
 		pinfo := plugin_common.FosetPlugin{
-			Filename : *sessionfile,
+			Filename : sessionfiles[0],
+			Filenames: sessionfiles,
 			Filter   : *filter,
 			Version  : mainVersion,
 			Commit   : fosetGitCommit,
@@ -146,7 +222,19 @@ func main() {
 	}
 
 	//
-	formatter, err := fortiformatter.Init(*output, &data_request)
+	var formatter *fortiformatter.Formatter
+	switch {
+	case len(*csv_cols) > 0:
+		formatter, err = fortiformatter.InitCSV(*csv_cols, false, &data_request)
+	case len(*tsv_cols) > 0:
+		formatter, err = fortiformatter.InitCSV(*tsv_cols, true, &data_request)
+	case *outformat == OUTPUT_JSON:
+		formatter, err = fortiformatter.InitJSON(*output, true, &data_request)
+	case *outformat == OUTPUT_NDJSON:
+		formatter, err = fortiformatter.InitJSON(*output, false, &data_request)
+	default:
+		formatter, err = fortiformatter.Init(*output, &data_request)
+	}
 	if err != nil {
 		log.Criticalf("Cannot parse output format: %s\n", err)
 		os.Exit(100)
@@ -155,7 +243,14 @@ func main() {
 
 	var conditioner *forticonditioner.Condition = nil
 	if len(*filter) > 0 {
-		conditioner = forticonditioner.Init(*filter, &data_request)
+		conditioner, err = forticonditioner.Init(*filter, &data_request)
+		if err != nil {
+			log.Criticalf("Cannot parse filter: %s", err)
+			if ferr, ok := err.(*forticonditioner.FilterError); ok {
+				fmt.Fprintf(os.Stderr, "%s\n", ferr.Pointer())
+			}
+			os.Exit(100)
+		}
 	}
 	log.Debugf("Parser request struct after conditioner init: %#v", data_request)
 
@@ -163,6 +258,16 @@ func main() {
 		data_request.SetAll()
 	}
 
+	// serial number is needed to identify the session in parse errors
+	if (*strict) {
+		data_request.Serial = true
+	}
+
+	// explained sessions are selected and printed by their serial numbers
+	if len(*explain) > 0 {
+		data_request.Serial = true
+	}
+
 	if len(*filter) > 0 {
 		log.Debugf("Original filter: \"%s\"", *filter)
 		log.Debugf("Parsed filter:")
@@ -177,9 +282,10 @@ func main() {
 	//
 	ep := ExecuteParams {
 		threads        : *threads,
-		sessionfile    : *sessionfile,
+		sessionfiles   : sessionfiles,
 		nobuffer       : *nobuffer,
 		gzip_in        : *gzip_in,
+		input_format   : *informat,
 		cache_save     : *cache_save,
 		cache_read     : *cache_read,
 		data_request   : &data_request,
@@ -188,8 +294,48 @@ func main() {
 		plugins        : plugins,
 		outfile        : *outfile,
 		progfile       : *progfile,
+		strict         : *strict,
+		benchmark      : *benchmark,
+		explain        : *explain,
+		snapshot       : uint32(*snapshot),
+		normalize      : !(*no_normal),
+	}
+
+	if *snapshot < 0 {
+		fmt.Println("Snapshot index cannot be negative")
+		os.Exit(1)
+	}
+
+	if len(*explain) > 0 {
+		if conditioner == nil {
+			fmt.Println("Explain requires filter")
+			os.Exit(1)
+		}
+		if _, err := InitExplainer(*explain); err != nil {
+			fmt.Printf("Invalid explain parameter: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// cache file is always written and read as a whole
+	if *per_snap && (*cache_read || *cache_save) {
+		fmt.Println("Cannot process snapshots separately when reading from cache")
+		os.Exit(1)
+	}
+
+	// cache file belongs to one input file
+	if len(sessionfiles) > 1 && (*cache_read || *cache_save) {
+		fmt.Println("Cache can only be used with one input file")
+		os.Exit(1)
 	}
 
+	// sources processed together in one run
+	var sources [][]string
+	if *per_source {
+		for _, f := range sessionfiles { sources = append(sources, []string{ f }) }
+	} else {
+		sources = [][]string{ sessionfiles }
+	}
 
 	for i := 0; i < *loop || *loop == 0; i++ {
 		log.Debugf("Starting next cycle")
@@ -201,8 +347,32 @@ func main() {
 			break
 		}
 
-		run_plugins(plugins, PLUGINS_START, nil)
-		execute(ep)
+		// files used by the filter (like IP sets) can change between cycles
+		if i > 0 && conditioner != nil {
+			conditioner.Reload()
+		}
+
+		for _, source := range sources {
+			ep.sessionfiles = source
+			for _, p := range plugins {
+				p.Filename  = source[0]
+				p.Filenames = source
+			}
+
+			if *per_snap {
+				// the input is read only once, each snapshot continues where the previous one stopped
+				ep.resume = OpenSessionInputs{}
+				for ep.snapshot = 1; ; ep.snapshot++ {
+					log.Debugf("Processing snapshot %d", ep.snapshot)
+					run_plugins(plugins, PLUGINS_START, nil)
+					if execute(ep) <= ep.snapshot { break }
+				}
+				ep.resume.Close()
+			} else {
+				run_plugins(plugins, PLUGINS_START, nil)
+				execute(ep)
+			}
+		}
 		runtime.GC()
 
 		took  := time.Now().Sub(start)
@@ -221,3 +391,19 @@ func main() {
 	}
 }
 
+// check_filter parses the filter without reading any sessions and exits,
+// the exit code is 0 when the filter is correct
+func check_filter(filter string) {
+	conditioner, err := forticonditioner.Init(filter, &fortisession.SessionDataRequest{})
+	if err != nil {
+		fmt.Printf("Filter error: %s\n", err)
+		if ferr, ok := err.(*forticonditioner.FilterError); ok {
+			fmt.Printf("%s\n", ferr.Pointer())
+		}
+		os.Exit(1)
+	}
+
+	log.Debugf("Parsed filter:\n%s", conditioner.DumpPretty())
+	fmt.Println("Filter is valid")
+	os.Exit(0)
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// ANSI escape sequences (CSI, OSC and the short ones)
+var ansi_escape = regexp.MustCompile("\x1b(\\[[0-9;?]*[ -/]*[@-~]|\\][^\x07\x1b]*(\x07|\x1b\\\\)|[()][0-9A-Za-z]|[=>78DEHMc])")
+// FortiOS pager prompt
+var pager_prompt = []byte("--More--")
+// beginnings of the lines in session dumps, such line is never a wrapped part of the previous one
+var line_starts = regexp.MustCompile("^\\s*(session6? info:|npu-session info:|origin-shaper=|reply-shaper=|per_ip_shaper=|class_id=|" +
+	"state=|statistic\\(|tx speed\\(|orgin->sink:|hook=|pos/|src_mac=|misc=|serial=|sdwan_mbr_seq=|rpdb_link_id=|npu_state=|" +
+	"npu info:|vlifid=|no_ofld_reason:|ofld_fail_reason|reflect info|user=|auth_server=|total session|path:|System time:)")
+
+// NormalizeCorrections counts the terminal capture artifacts removed from the input.
+type NormalizeCorrections struct {
+	Pagers          uint64  // removed "--More--" prompts
+	Escapes         uint64  // removed ANSI escape sequences
+	Backspaces      uint64  // applied backspaces
+	CarriageReturns uint64  // lines overwritten after carriage return
+	LineEndings     uint64  // CRLF line endings
+	Wraps           uint64  // rejoined wrapped lines
+}
+
+func (c *NormalizeCorrections) Total() uint64 {
+	return c.Pagers + c.Escapes + c.Backspaces + c.CarriageReturns + c.LineEndings + c.Wraps
+}
+
+func (c *NormalizeCorrections) String() string {
+	parts := make([]string, 0)
+	if c.Pagers          > 0 { parts = append(parts, fmt.Sprintf("%d pager prompts", c.Pagers)) }
+	if c.Escapes         > 0 { parts = append(parts, fmt.Sprintf("%d escape sequences", c.Escapes)) }
+	if c.Backspaces      > 0 { parts = append(parts, fmt.Sprintf("%d backspaces", c.Backspaces)) }
+	if c.CarriageReturns > 0 { parts = append(parts, fmt.Sprintf("%d overwritten lines", c.CarriageReturns)) }
+	if c.LineEndings     > 0 { parts = append(parts, fmt.Sprintf("%d CRLF line endings", c.LineEndings)) }
+	if c.Wraps           > 0 { parts = append(parts, fmt.Sprintf("%d wrapped lines", c.Wraps)) }
+	return strings.Join(parts, ", ")
+}
+
+// NormalizingReader removes the artifacts of the terminal capture (like Putty or `script` logs)
+// from the data read from the source reader, so the session dump can be parsed reliably.
+type NormalizingReader struct {
+	src          *bufio.Reader
+	out          bytes.Buffer  // normalized data ready to be read
+	held         []byte        // last line, it can still be followed by its wrapped part
+	held_last    int           // length of the last part of the held line
+	width        int           // console width causing the wraps (0 if there are no wraps)
+	eof          bool
+	Corrections  NormalizeCorrections
+}
+
+func InitNormalizingReader(src io.Reader) (*NormalizingReader) {
+	nr := NormalizingReader {
+		src : bufio.NewReaderSize(src, 64*1024),
+	}
+
+	// console width can only be detected from the data itself
+	peek, _ := nr.src.Peek(64*1024)
+	nr.width = detect_wrap_width(peek)
+	if nr.width > 0 { log.Debugf("Detected wrapped lines at width %d", nr.width) }
+
+	return &nr
+}
+
+func (nr *NormalizingReader) Read(p []byte) (int, error) {
+	for nr.out.Len() == 0 && !nr.eof {
+		line, err := nr.src.ReadBytes('\n')
+		if err != nil { nr.eof = true }
+		if len(line) == 0 { continue }
+
+		// ReadBytes keeps the new line at the end
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		cleaned, keep := normalize_line(line, &nr.Corrections)
+		if keep { nr.push(cleaned) }
+	}
+
+	if nr.eof && nr.held != nil {
+		nr.out.Write(nr.held)
+		nr.out.WriteByte('\n')
+		nr.held = nil
+	}
+
+	if nr.out.Len() == 0 && nr.eof { return 0, io.EOF }
+	return nr.out.Read(p)
+}
+
+// push joins the line with the held one if that one was wrapped,
+// otherwise the held line is released to the output.
+// The held line ending exactly at the console width is not enough, because clean dumps
+// can have many lines of the same length, the line must also not start as a new session line.
+func (nr *NormalizingReader) push(line []byte) {
+	if nr.held != nil && nr.width > 0 && nr.held_last == nr.width && len(line) > 0 && !line_starts.Match(line) {
+		nr.held      = append(nr.held, line...)
+		nr.held_last = len(line)
+		nr.Corrections.Wraps += 1
+		return
+	}
+
+	if nr.held != nil {
+		nr.out.Write(nr.held)
+		nr.out.WriteByte('\n')
+	}
+
+	// empty line must be held too (as non-nil)
+	nr.held      = append([]byte{}, line...)
+	nr.held_last = len(line)
+}
+
+// normalize_line removes the artifacts from one line (without new line character at the end),
+// the correction counters are updated if `corrections` is not nil.
+// If the line only contained the artifacts, it should not be kept at all.
+func normalize_line(line []byte, corrections *NormalizeCorrections) (cleaned []byte, keep bool) {
+	var c NormalizeCorrections
+
+	if bytes.HasSuffix(line, []byte("\r")) {
+		line = bytes.TrimRight(line, "\r")
+		c.LineEndings += 1
+	}
+
+	// fast path for the lines without any artifacts
+	if bytes.IndexAny(line, "\x1b\b\r") == -1 && !bytes.Contains(line, pager_prompt) {
+		if corrections != nil { corrections.LineEndings += c.LineEndings }
+		return line, true
+	}
+
+	original := len(bytes.TrimSpace(line))
+
+	if n := len(ansi_escape.FindAllIndex(line, -1)); n > 0 {
+		line = ansi_escape.ReplaceAll(line, nil)
+		c.Escapes += uint64(n)
+	}
+
+	if n := bytes.Count(line, pager_prompt); n > 0 {
+		line = bytes.ReplaceAll(line, pager_prompt, nil)
+		c.Pagers += uint64(n)
+	}
+
+	if n := bytes.Count(line, []byte("\b")); n > 0 {
+		applied := make([]byte, 0, len(line))
+		for _, b := range line {
+			if b != '\b' {
+				applied = append(applied, b)
+			} else if len(applied) > 0 {
+				applied = applied[:len(applied)-1]
+			}
+		}
+		line = applied
+		c.Backspaces += uint64(n)
+	}
+
+	// each part after carriage return overwrites the line from its beginning
+	if bytes.IndexByte(line, '\r') != -1 {
+		overwritten := make([]byte, 0, len(line))
+		for _, part := range bytes.Split(line, []byte("\r")) {
+			for i, b := range part {
+				if i < len(overwritten) { overwritten[i] = b } else { overwritten = append(overwritten, b) }
+			}
+		}
+		line = bytes.TrimRight(overwritten, " ")
+		c.CarriageReturns += 1
+	}
+
+	if corrections != nil {
+		corrections.Pagers          += c.Pagers
+		corrections.Escapes         += c.Escapes
+		corrections.Backspaces      += c.Backspaces
+		corrections.CarriageReturns += c.CarriageReturns
+		corrections.LineEndings     += c.LineEndings
+	}
+
+	// the line with only the pager prompt must not become an empty line,
+	// because that would end the session
+	if original > 0 && len(bytes.TrimSpace(line)) == 0 { return nil, false }
+	return line, true
+}
+
+// detect_wrap_width returns the console width if the lines in data seem to be wrapped
+// or 0 if they don't. Wrapped lines all have the same length and no line is longer.
+// Because the longest lines are the wrapped ones, there are more of them than the lines
+// that are just a little bit shorter (which is not true for the lines that are not wrapped).
+func detect_wrap_width(data []byte) int {
+	lengths := make(map[int]int)
+	longest := 0
+
+	lines := bytes.Split(data, []byte("\n"))
+	// the last line may be incomplete
+	if len(lines) > 1 { lines = lines[:len(lines)-1] }
+
+	for _, line := range lines {
+		cleaned, keep := normalize_line(line, nil)
+		if !keep { continue }
+		lengths[len(cleaned)] += 1
+		if len(cleaned) > longest { longest = len(cleaned) }
+	}
+
+	if longest < 40 || lengths[longest] < 3 { return 0 }
+
+	shorter := 0
+	for l := longest-10; l < longest; l++ { shorter += lengths[l] }
+	if lengths[longest] <= shorter { return 0 }
+
+	return longest
+}
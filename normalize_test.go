@@ -0,0 +1,73 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// clean_session has the longest line (the first one) repeated in every session,
+// which looks like wrapping at that width
+const clean_session = `session info: proto=6 proto_state=01 duration=1385 expire=3599 timeout=3600 flags=00000000 socktype=0 sockport=0 av_idx=0 use=4
+origin-shaper=shaperA prio=2 guarantee 0Bps max 1250000Bps traffic 0Bps drops 0B
+reply-shaper=
+per_ip_shaper=
+class_id=0 ha_id=0 policy_dir=0 tunnel=/ vlan_cos=0/255
+state=log may_dirty npu synced f00 log-start
+statistic(bytes/packets/allow_err): org=5228/89/1 reply=8348/123/1 tuples=2
+tx speed(Bps/kbps): 1/0 rx speed(Bps/kbps): 2/0
+orgin->sink: org pre->post, reply pre->post dev=9->54/54->9 gwy=193.86.26.193/172.26.81.24
+hook=post dir=org act=snat 10.109.3.14:37327->205.251.194.229:53(193.86.26.196:37327)
+hook=pre dir=reply act=dnat 205.251.194.229:53->193.86.26.196:37327(10.109.3.14:37327)
+pos/(before,after) 0/(0,0), 0/(0,0)
+src_mac=00:09:0f:09:00:02 dst_mac=00:09:0f:09:00:03
+misc=0 policy_id=4 auth_info=0 chk_client_info=0 vd=0
+serial=68fb0e9e tos=ff/ff app_list=2000 app=15895 url_cat=52
+npu_state=0x000c00
+no_ofld_reason:  offload-denied
+
+`
+
+func normalize(t *testing.T, input string) (string, *NormalizingReader) {
+	nr := InitNormalizingReader(strings.NewReader(input))
+	output, err := ioutil.ReadAll(nr)
+	if err != nil { t.Fatalf("cannot read normalized data: %s", err) }
+	return string(output), nr
+}
+
+func TestNormalizeCleanDumpUnchanged(t *testing.T) {
+	input := strings.Repeat(clean_session, 80)
+
+	output, nr := normalize(t, input)
+	if output != input {
+		t.Errorf("clean dump was changed by the normalizer: %s", nr.Corrections.String())
+	}
+	if nr.Corrections.Total() != 0 {
+		t.Errorf("unexpected corrections: %s", nr.Corrections.String())
+	}
+}
+
+func TestNormalizeWrappedLinesJoined(t *testing.T) {
+	// wrap every line longer than the console width
+	width := 40
+	var lines []string
+	for _, line := range strings.Split(clean_session, "\n") {
+		for len(line) > width {
+			lines = append(lines, line[:width])
+			line = line[width:]
+		}
+		lines = append(lines, line)
+	}
+	input := strings.Repeat(strings.Join(lines, "\n"), 5)
+
+	output, nr := normalize(t, input)
+	if nr.Corrections.Wraps == 0 {
+		t.Fatalf("wrapped lines were not detected")
+	}
+	if output != strings.Repeat(clean_session, 5) {
+		t.Errorf("wrapped lines were not joined correctly:\n%s", output)
+	}
+}
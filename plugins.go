@@ -17,6 +17,7 @@ import (
 	"foset/plugins/merge"
 	"foset/plugins/stats"
 	"foset/plugins/indexmap"
+	"foset/plugins/npumatch"
 )
 
 type pluginHook int
@@ -94,6 +95,8 @@ func load_internal_plugin(s string, data_request *fortisession.SessionDataReques
 		err = plugin_stats.InitPlugin(pluginInfo, data, data_request, log.Child("iplugin"))
 	} else if pluginspec == "indexmap" {
 		err = plugin_indexmap.InitPlugin(pluginInfo, data, data_request, log.Child("iplugin"))
+	} else if pluginspec == "npumatch" {
+		err = plugin_npumatch.InitPlugin(pluginInfo, data, data_request, log.Child("iplugin"))
 	} else if pluginspec == "example" {
 		 err = plugin_example.InitPlugin(pluginInfo, data, data_request, log.Child("iplugin"))
 	} else {
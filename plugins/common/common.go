@@ -27,7 +27,8 @@ type FosetPlugin struct {
 	// Parameters filled by main part of Foset for use inside of plugins
 	Version   string
 	Commit    string
-	Filename  string
+	Filename  string    // first input file
+	Filenames []string  // all input files processed together
 	Filter    string
 
 	Inputs    *iproviders.IProviders
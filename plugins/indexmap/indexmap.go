@@ -7,14 +7,28 @@
 // It accepts parameters "vdoms" and/or "interfaces" that specify the path to the file containg 
 // the output of "diag sys vd list" or "diag netlink interface list".
 //
+// Parameters "apps" and/or "urlcats" specify the path to the local file with the list
+// of application or URL category IDs and their names (one "id name" pair on each line).
+//
+// Parameters "sdwanmembers" and/or "sdwanservices" specify the path to the file containing
+// the output of "diag sys sdwan member" or "diag sys sdwan service".
+//
+// Parameter "auto" specifies the file containing outputs of multiple commands (or the session
+// file itself if no value is given), the VDOM and interface lists are recognized automatically there.
+// All files can be compressed.
+//
 // For each session the plugin creates custom value (string based) called "vdom" if "vdoms"
 // parameter was given and/or "iface[??]" (check formatter help for the options) if "interfaces"
-// parameter was given. If the index wasn't found in the parsed files, the custom variable
+// parameter was given and/or "app" and "urlcat" if "apps" or "urlcats" parameters
+// were given and/or "sdwanmbr" and "sdwansvc" if "sdwanmembers" or "sdwanservices"
+// parameters were given. If the index wasn't found in the parsed files, the custom variable
 // is still present but contain the index as string.
 package plugin_indexmap
 
 import (
+	"io"
 	"fmt"
+	"bytes"
 	"strconv"
 	"bufio"
 	"strings"
@@ -34,6 +48,10 @@ var plugin *plugin_common.FosetPlugin
 // dictionaries
 var dict_vdoms       map[uint32]string
 var dict_interfaces  map[uint32]string
+var dict_apps        map[uint32]string
+var dict_urlcats     map[uint32]string
+var dict_sdwan_mbrs  map[uint32]string
+var dict_sdwan_svcs  map[uint32]string
 
 //
 func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request *fortisession.SessionDataRequest, custom_log loggo.Logger) (error) {
@@ -45,7 +63,7 @@ func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request
 
 	// parse data parameters
 	defaults := make(map[string]string)
-	dk, du, _ := common.ExtractData(data, []string{"vdoms","interfaces"}, defaults)
+	dk, du, _ := common.ExtractData(data, []string{"vdoms","interfaces","apps","urlcats","sdwanmembers","sdwanservices","auto"}, defaults)
 
 	// validate parameters
 	unknowns := make([]string, 0)
@@ -55,6 +73,33 @@ func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request
 	}
 
 	// what to do?
+	auto, exists := dk["auto"]
+	if exists {
+		// without value the session files themselves are used (the first one containing the lists),
+		// standard input cannot be read here because the sessions would be lost
+		files := []string{ auto }
+		if auto == "" { files = plugin.Filenames }
+
+		for _, file := range files {
+			if file == "-" { return fmt.Errorf("auto without file name cannot be used when reading sessions from standard input") }
+		}
+
+		for _, file := range files {
+			contents, err := parseAuto(file)
+			if err != nil { return fmt.Errorf("cannot parse auto file: %s", err) }
+			log.Debugf("Detected contents of \"%s\": %s", file, strings.Join(contents, ", "))
+			auto = file
+			if dict_vdoms != nil || dict_interfaces != nil { break }
+		}
+
+		if dict_vdoms == nil && dict_interfaces == nil {
+			log.Warningf("No VDOM or interface list found in \"%s\"", auto)
+		}
+		if dict_vdoms      != nil { data_request.Policy     = true }
+		if dict_interfaces != nil { data_request.Interfaces = true }
+		data_request.Custom = true
+	}
+
 	vdoms, _ := dk["vdoms"]
 	if vdoms != "" {
 		err := parseVdoms(vdoms)
@@ -71,6 +116,40 @@ func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request
 		data_request.Custom = true
 	}
 
+	apps, _ := dk["apps"]
+	if apps != "" {
+		var err error
+		dict_apps, err = parseIdList(apps)
+		if err != nil { return fmt.Errorf("cannot parse apps file: %s", err) }
+		data_request.App = true
+		data_request.Custom = true
+	}
+
+	urlcats, _ := dk["urlcats"]
+	if urlcats != "" {
+		var err error
+		dict_urlcats, err = parseIdList(urlcats)
+		if err != nil { return fmt.Errorf("cannot parse urlcats file: %s", err) }
+		data_request.App = true
+		data_request.Custom = true
+	}
+
+	sdwanmembers, _ := dk["sdwanmembers"]
+	if sdwanmembers != "" {
+		err := parseSdwanMembers(sdwanmembers)
+		if err != nil { return fmt.Errorf("cannot parse sdwanmembers file: %s", err) }
+		data_request.Sdwan = true
+		data_request.Custom = true
+	}
+
+	sdwanservices, _ := dk["sdwanservices"]
+	if sdwanservices != "" {
+		err := parseSdwanServices(sdwanservices)
+		if err != nil { return fmt.Errorf("cannot parse sdwanservices file: %s", err) }
+		data_request.Sdwan = true
+		data_request.Custom = true
+	}
+
 	// setup callbacks
 	var hooks plugin_common.Hooks
 	hooks.BeforeFilter = ProcessBeforeFilter
@@ -133,14 +212,124 @@ func ProcessBeforeFilter(session *fortisession.Session) bool {
 
 	}
 
+	// do we have some application map?
+	if dict_apps != nil {
+		name, exists := dict_apps[session.App.Id]
+		if exists {
+			session.Custom["app"] = multivalue.NewString(name)
+		} else {
+			session.Custom["app"] = multivalue.NewString(fmt.Sprintf("%d", session.App.Id))
+		}
+	}
+
+	// do we have some url category map?
+	if dict_urlcats != nil {
+		name, exists := dict_urlcats[session.App.UrlCat]
+		if exists {
+			session.Custom["urlcat"] = multivalue.NewString(name)
+		} else {
+			session.Custom["urlcat"] = multivalue.NewString(fmt.Sprintf("%d", session.App.UrlCat))
+		}
+	}
+
+	// do we have some sdwan member map?
+	if dict_sdwan_mbrs != nil {
+		name, exists := dict_sdwan_mbrs[session.Sdwan.MemberSeq]
+		if exists {
+			session.Custom["sdwanmbr"] = multivalue.NewString(name)
+		} else {
+			session.Custom["sdwanmbr"] = multivalue.NewString(fmt.Sprintf("%d", session.Sdwan.MemberSeq))
+		}
+	}
+
+	// do we have some sdwan service map?
+	if dict_sdwan_svcs != nil {
+		name, exists := dict_sdwan_svcs[session.Sdwan.ServiceId]
+		if exists {
+			session.Custom["sdwansvc"] = multivalue.NewString(name)
+		} else {
+			session.Custom["sdwansvc"] = multivalue.NewString(fmt.Sprintf("%d", session.Sdwan.ServiceId))
+		}
+	}
+
 	return false
 }
 
+// inputReader closes both the decompressor and the input when it is closed
+type inputReader struct {
+	io.ReadCloser
+	input  io.Reader
+}
+
+func (r inputReader) Close() error {
+	r.ReadCloser.Close()
+	return common.CloseInput(r.input)
+}
+
+// openInput opens the file using the input providers and decompresses it if needed,
+// the returned reader must be closed
+func openInput(filename string) (io.ReadCloser, error) {
+	f, _, err := plugin.Inputs.ProvideReader(filename)
+	if err != nil { return nil, err }
+
+	r, _, err := common.Decompress(f)
+	if err != nil {
+		common.CloseInput(f)
+		return nil, err
+	}
+	return inputReader{ r, f }, nil
+}
+
+// parseAuto reads the file with outputs of multiple commands (like the support
+// text file) and parses all the recognized outputs from it. The session lists
+// are skipped while reading, so only the rest of the file is kept in memory.
+func parseAuto(filename string) ([]string, error) {
+	f, err := openInput(filename)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	var rest    bytes.Buffer
+	var session bool
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// session paragraph ends with an empty line
+		if bytes.HasPrefix(line, []byte("session info:")) || bytes.HasPrefix(line, []byte("session6 info:")) || bytes.HasPrefix(line, []byte("npu-session info:")) {
+			session = true
+		}
+		if session && len(bytes.TrimSpace(line)) == 0 { session = false }
+		if session || fortisession.IsShortSessionLine(line) { continue }
+
+		rest.Write(line)
+		rest.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil { return nil, err }
+
+	contents := common.DetectContent(rest.Bytes())
+	for _, content := range contents {
+		if content == common.CONTENT_VD_LIST {
+			err = readVdoms(bytes.NewReader(rest.Bytes()))
+		} else if content == common.CONTENT_INTERFACE_LIST {
+			err = readInterfaces(bytes.NewReader(rest.Bytes()))
+		}
+		if err != nil { return nil, err }
+	}
+
+	return contents, nil
+}
+
 // parsing functions
 func parseVdoms(filename string) error {
-	f, _, err := plugin.Inputs.ProvideReader(filename)
+	f, err := openInput(filename)
 	if err != nil { return err }
+	defer f.Close()
+	return readVdoms(f)
+}
 
+func readVdoms(f io.Reader) error {
 	re := regexp.MustCompile("^name=([^/]+).*?\\sindex=([0-9]+)")
 	dict_vdoms = make(map[uint32]string)
 
@@ -174,9 +363,13 @@ func parseVdoms(filename string) error {
 }
 
 func parseInterfaces(filename string) error {
-	f, _, err := plugin.Inputs.ProvideReader(filename)
+	f, err := openInput(filename)
 	if err != nil { return err }
+	defer f.Close()
+	return readInterfaces(f)
+}
 
+func readInterfaces(f io.Reader) error {
 	// if=mgmt1 family=00 type=1 index=3 mtu=1500 link=0 master=0
 	re := regexp.MustCompile("^if=([^ ]+)\\s+family=.*?\\sindex=([0-9]+)")
 	dict_interfaces = make(map[uint32]string)
@@ -201,3 +394,127 @@ func parseInterfaces(filename string) error {
 
 	return nil
 }
+
+func parseIdList(filename string) (map[uint32]string, error) {
+	f, err := openInput(filename)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	// 15832 Facebook
+	// 52,Information Technology
+	re := regexp.MustCompile("^([0-9]+)[\\s,;=]+(.+)$")
+	dict := make(map[uint32]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") { continue }
+
+		results := re.FindStringSubmatch(line)
+		if len(results) == 0 { continue }
+
+		name  := strings.Trim(strings.TrimSpace(results[2]), "\"")
+		tmp, err := strconv.ParseUint(results[1], 10, 32)
+		if err != nil {
+			log.Errorf("Cannot parse id \"%s\" for \"%s\": %s", results[1], name, err)
+			continue
+		}
+		index := uint32(tmp)
+
+		dict[index] = name
+	}
+
+	return dict, nil
+}
+
+func parseSdwanMembers(filename string) error {
+	f, err := openInput(filename)
+	if err != nil { return err }
+	defer f.Close()
+
+	// Member(1): interface: port1, flags=0x0 , gateway: 10.0.0.1, priority: 0 1024, weight: 0
+	re := regexp.MustCompile("^Member\\(([0-9]+)\\):\\s+interface:\\s+([^,\\s]+)")
+	dict_sdwan_mbrs = make(map[uint32]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		results := re.FindStringSubmatch(line)
+		if len(results) == 0 { continue }
+
+		name  := results[2]
+		tmp, err := strconv.ParseUint(results[1], 10, 32)
+		if err != nil {
+			log.Errorf("Cannot parse sequence number \"%s\" for SD-WAN member \"%s\": %s", results[1], name, err)
+			continue
+		}
+		index := uint32(tmp)
+
+		dict_sdwan_mbrs[index] = name
+	}
+
+	return nil
+}
+
+func parseSdwanServices(filename string) error {
+	f, err := openInput(filename)
+	if err != nil { return err }
+	defer f.Close()
+
+	// Service(1): Address Mode(IPV4) flags=0x200 use-shortcut-sla
+	//   Gen(1), TOS(0x0/0x0), Protocol(0: 1->65535), Mode(sla), sla-compare-order
+	//   Members(2):
+	//     1: Seq_num(1 port1), alive, sla(0x1), gid(0), cfg_order(0), cost(0), selected
+	//     2: Seq_num(2 port2), alive, sla(0x1), gid(0), cfg_order(1), cost(0), selected
+	re_service := regexp.MustCompile("^Service\\(([0-9]+)\\):")
+	re_mode    := regexp.MustCompile("\\sMode\\(([^)]+)\\)")
+	re_member  := regexp.MustCompile("^[0-9]+:\\s+Seq_num\\([0-9]+\\s+([^)\\s]+)")
+	dict_sdwan_svcs = make(map[uint32]string)
+
+	// service is described by its mode and members in the preferred order,
+	// ie. "sla(port1,port2)", because the service name is not in the output
+	var index   uint32
+	var inside  bool
+	var mode    string
+	var members []string
+
+	save := func() {
+		if !inside { return }
+		dict_sdwan_svcs[index] = fmt.Sprintf("%s(%s)", mode, strings.Join(members, ","))
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		results := re_service.FindStringSubmatch(line)
+		if len(results) > 0 {
+			save()
+
+			tmp, err := strconv.ParseUint(results[1], 10, 32)
+			if err != nil {
+				log.Errorf("Cannot parse id \"%s\" for SD-WAN service: %s", results[1], err)
+				inside = false
+				continue
+			}
+
+			index   = uint32(tmp)
+			inside  = true
+			mode    = "-"
+			members = make([]string, 0)
+			continue
+		}
+
+		if !inside { continue }
+
+		results = re_mode.FindStringSubmatch(" " + line)
+		if len(results) > 0 { mode = results[1] }
+
+		results = re_member.FindStringSubmatch(line)
+		if len(results) > 0 { members = append(members, results[1]) }
+	}
+	save()
+
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+// Plugin npumatch correlates the kernel sessions with the hardware sessions from the NPU
+// session table.
+//
+// It accepts the parameter "file" which specifies the path to the file containing the output
+// of "diag sys npu-session list".
+//
+// Sessions are matched by the protocol, source and destination IP addresses and ports
+// in the original direction. For each matching kernel session the `NpuSession` structure
+// is filled with the data from the hardware session. Kernel sessions without the hardware
+// session keep the `NpuSession.Valid` false.
+package plugin_npumatch
+
+import (
+	"fmt"
+	"bufio"
+	"strings"
+	"foset/common"
+	"foset/fortisession"
+	"foset/plugins/common"
+	"github.com/juju/loggo"
+)
+
+// parameters saved from InitPlugin
+var log loggo.Logger
+
+var plugin *plugin_common.FosetPlugin
+
+// hardware sessions by the 5-tuple key
+var npu_sessions map[string]*fortisession.NpuSession
+
+//
+func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request *fortisession.SessionDataRequest, custom_log loggo.Logger) (error) {
+	// setup logging with custom name (to differentiate from other plugins)
+	log = custom_log.Child("npumatch")
+
+	// save our plugin info
+	plugin = pluginInfo
+
+	// parse data parameters
+	defaults := make(map[string]string)
+	dk, du, _ := common.ExtractData(data, []string{"file"}, defaults)
+
+	// validate parameters
+	unknowns := make([]string, 0)
+	for k, _ := range du { unknowns = append(unknowns, k) }
+	if len(unknowns) > 0 {
+		return fmt.Errorf("following parameters are not recognized: %s", strings.Join(unknowns, ", "))
+	}
+
+	if len(dk["file"]) == 0 {
+		return fmt.Errorf("file parameter missing")
+	}
+
+	err := parseNpuSessions(dk["file"])
+	if err != nil { return fmt.Errorf("cannot parse npu sessions file: %s", err) }
+	log.Debugf("Loaded %d NPU sessions", len(npu_sessions))
+
+	// request fields
+	data_request.Hooks      = true
+	data_request.Basics     = true
+	data_request.NpuSession = true
+
+	// setup callbacks
+	var hooks plugin_common.Hooks
+	hooks.BeforeFilter = ProcessBeforeFilter
+
+	pluginInfo.Hooks = hooks
+
+	//
+	return nil
+}
+
+func ProcessBeforeFilter(session *fortisession.Session) bool {
+	// hardware sessions loaded from the main input are not touched
+	if session.NpuSession.Valid { return false }
+
+	npus, exists := npu_sessions[sessionKey(session)]
+	if exists {
+		*session.NpuSession = *npus
+	}
+
+	return false
+}
+
+// sessionKey returns the string identifying the session by its 5-tuple
+func sessionKey(session *fortisession.Session) string {
+	src_ip, src_port, dst_ip, dst_port, _, _, _ := session.GetPeers()
+	return fmt.Sprintf("%d %s %d %s %d", session.Basics.Protocol, src_ip, src_port, dst_ip, dst_port)
+}
+
+// parsing functions
+func parseNpuSessions(filename string) error {
+	f, _, err := plugin.Inputs.ProvideReader(filename)
+	if err != nil { return err }
+	defer common.CloseInput(f)
+
+	npu_sessions = make(map[string]*fortisession.NpuSession)
+	request := fortisession.SessionDataRequest {
+		Hooks      : true,
+		Basics     : true,
+		NpuSession : true,
+	}
+
+	save := func(lines []string) {
+		if len(lines) == 0 { return }
+
+		// the parser expects the new line before the first line of session
+		session := fortisession.Parse([]byte("\n" + strings.Join(lines, "\n")), &request)
+		if !session.NpuSession.Valid { return }
+
+		key := sessionKey(session)
+		if _, exists := npu_sessions[key]; exists {
+			log.Debugf("Duplicate NPU session \"%s\"", key)
+			return
+		}
+		npu_sessions[key] = session.NpuSession
+	}
+
+	// each session starts with "npu-session info:" and ends with an empty line
+	// or with the start of the next session
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "npu-session info:") {
+			save(lines)
+			lines = []string{ line }
+		} else if line == "" {
+			save(lines)
+			lines = nil
+		} else if lines != nil {
+			lines = append(lines, line)
+		}
+	}
+	save(lines)
+
+	return nil
+}
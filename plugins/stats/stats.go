@@ -16,6 +16,7 @@ import (
 	"sync/atomic"
 	"foset/common"
 	"foset/fortisession"
+	"foset/fortisession/fortifields"
 	"foset/plugins/common"
 	"github.com/juju/loggo"
 )
@@ -31,6 +32,7 @@ var directory_override   bool
 var use_complex_matching bool
 var srcprefix, dstprefix uint32
 var srcmask, dstmask     uint32
+var srcprefix6, dstprefix6 uint32
 var translate_vdoms      bool
 var translate_interfaces bool
 
@@ -66,10 +68,6 @@ var nexthop_org, nexthop_rev, nexthop_orgrev *Counter
 var offload_npu, offload_nturbo *Counter
 var offload_fail, offload_fail_org, offload_fail_rev *Counter
 
-// dictionaries definition
-var dict_tcp_session_state map[uint8]string
-var dict_udp_session_state map[uint8]string
-
 // session counters
 var count_total    uint64  // all sessions
 var count_matched  uint64  // session matching filter
@@ -93,7 +91,9 @@ func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request
 	defaults := make(map[string]string)
 	defaults["srcprefix"] = "24"
 	defaults["dstprefix"] = "24"
-	dk, du, _ := common.ExtractData(data, []string{"srcprefix","dstprefix","complex","directory","force","transvdoms","transifaces","name"}, defaults)
+	defaults["srcprefix6"] = "64"
+	defaults["dstprefix6"] = "64"
+	dk, du, _ := common.ExtractData(data, []string{"srcprefix","dstprefix","srcprefix6","dstprefix6","complex","directory","force","transvdoms","transifaces","name"}, defaults)
 
 	// validate parameters
 	unknowns := make([]string, 0)
@@ -141,29 +141,31 @@ func InitPlugin(pluginInfo *plugin_common.FosetPlugin, data string, data_request
 	dstprefix = uint32(tmp)
 	dstmask   = uint32(math.Pow(float64(2), float64(dstprefix))-1) << (32-dstprefix)
 
+	// the same for IPv6 sessions
+	tmp, err = strconv.ParseUint(dk["srcprefix6"], 10, 32)
+	if err != nil { return fmt.Errorf("parameter srcprefix6 unparsable: %s", err) }
+	if tmp == 0 || tmp > 128 { return fmt.Errorf("nonsence srcprefix6 length %d", tmp) }
+	srcprefix6 = uint32(tmp)
+
+	tmp, err = strconv.ParseUint(dk["dstprefix6"], 10, 32)
+	if err != nil { return fmt.Errorf("parameter dstprefix6 unparsable: %s", err) }
+	if tmp == 0 || tmp > 128 { return fmt.Errorf("nonsence dstprefix6 length %d", tmp) }
+	dstprefix6 = uint32(tmp)
+
 	// by default the complex matching (networks-to-networks, ports-to-ports) are disabled
 	// because those are extremely memory exhausing
 	_, use_complex_matching = dk["complex"]
 
 	// save the public parts of config string
-	config = fmt.Sprintf("srcprefix=%d,dstprefix=%d", srcprefix, dstprefix)
+	config = fmt.Sprintf("srcprefix=%d,dstprefix=%d,srcprefix6=%d,dstprefix6=%d", srcprefix, dstprefix, srcprefix6, dstprefix6)
 	if use_complex_matching { config += ",complex" }
 	if translate_vdoms      { config += ",transvdoms" }
 	if translate_interfaces { config += ",transifaces" }
 
 	// request fields
-	data_request.Hooks      = true
-	data_request.Basics     = true
-	data_request.Rate       = true
-	data_request.Stats      = true
-	data_request.Policy     = true
-	data_request.Interfaces = true
-	data_request.Npu        = true
-	data_request.NpuError   = true
-	data_request.Other      = true
-	data_request.Auth       = true
-	data_request.States     = true
-	data_request.Shaping    = true
+	err = fortifields.Require(data_request, "host", "port", "proto", "status", "duration", "timeout", "policy", "vdom",
+		"rate", "count[ob]", "iface", "nexthop", "offload", "nturbo", "nooff[no]", "helper", "tunnel", "user", "state", "shaper")
+	if err != nil { return err }
 
 	// setup callbacks
 	var hooks plugin_common.Hooks
@@ -237,10 +239,6 @@ func initCounters() {
 	udp_sstateR           = CounterInit("udp_sstate_r", WriteSimpleData)
 	udp_sstateLR          = CounterInit("udp_sstate_lr", WriteSimpleData)
 
-	// initialize and fill maps with dictionaries
-	initDictTCPState();
-	initDictUDPState();
-
 	//
 	count_total = 0
 	count_matched = 0
@@ -278,11 +276,11 @@ func ProcessAfterFilter(session *fortisession.Session) bool {
 
 	//
 	src_ip, src_port, dst_ip, dst_port, nat_ip, nat_port, _ := session.GetPeers()
-	srcnet := getNetwork(src_ip, srcmask)
-	dstnet := getNetwork(dst_ip, dstmask)
+	srcnet := getNetwork(src_ip, srcmask, srcprefix6)
+	dstnet := getNetwork(dst_ip, dstmask, dstprefix6)
 
-	snatip := getNetwork(nat_ip, 0xffffffff)
-	if snatip != 0 {
+	snatip := getNetwork(nat_ip, 0xffffffff, 128)
+	if !isEmptyNetwork(snatip) {
 		snat_ip.AddOne(snatip)
 	}
 	if nat_port != 0 {
@@ -342,11 +340,11 @@ func ProcessAfterFilter(session *fortisession.Session) bool {
 	dstnetworks_counts.Add(dstnet, session.Stats.Packets_rev)
 	srcnetworks_errs.Add(srcnet, session.Stats.Errors_org)
 	dstnetworks_errs.Add(dstnet, session.Stats.Errors_rev)
-	nexthop_org.AddOne(getNetwork(session.Interfaces.NextHop_org, 0xffffffff))
-	nexthop_rev.AddOne(getNetwork(session.Interfaces.NextHop_rev, 0xffffffff))
+	nexthop_org.AddOne(getNetwork(session.Interfaces.NextHop_org, 0xffffffff, 128))
+	nexthop_rev.AddOne(getNetwork(session.Interfaces.NextHop_rev, 0xffffffff, 128))
 
 	if use_complex_matching {
-		srcdstnet  := uint64(srcnet) << 32 | uint64(dstnet)
+		srcdstnet  := getNetworkPair(srcnet, dstnet)
 
 		srcdstnetworks.AddOne(srcdstnet)
 		srcdstnetworks_rate.Add(srcdstnet, session.Rate.Tx_Bps + session.Rate.Rx_Bps)
@@ -354,8 +352,8 @@ func ProcessAfterFilter(session *fortisession.Session) bool {
 		srcdstnetworks_counts.Add(srcdstnet, session.Stats.Packets_org + session.Stats.Packets_rev)
 		srcdstnetworks_errs.Add(srcdstnet, session.Stats.Errors_org + session.Stats.Errors_rev)
 
-		snatipport := uint64(snatip) << 32 | uint64(nat_port)
-		if snatipport != 0 {
+		snatipport := getNetworkPort(snatip, nat_port)
+		if !isEmptyNetwork(snatip) || nat_port != 0 {
 			snat_ipport.AddOne(snatipport)
 		}
 
@@ -491,7 +489,13 @@ func ProcessFinished() {
 	fmt.Fprintf(f, "var current = Object()\n")
 	fmt.Fprintf(f, "current.info = Object()\n")
 	if filename == "" {
-		fmt.Fprintf(f, "current.info.filename         = \"%s\"\n", path.Base(plugin.Filename))
+		// all input files processed together are named in the result
+		names := []string{ path.Base(plugin.Filename) }
+		if len(plugin.Filenames) > 1 {
+			names = make([]string, len(plugin.Filenames))
+			for i, n := range plugin.Filenames { names[i] = path.Base(n) }
+		}
+		fmt.Fprintf(f, "current.info.filename         = \"%s\"\n", strings.Join(names, ", "))
 	} else {
 		fmt.Fprintf(f, "current.info.filename         = \"%s\"\n", filename)
 	}
@@ -531,30 +535,36 @@ func ProcessFinished() {
 	// other times the `key` has more complicated behavior
 	// (like containg IP address (32 bits) as well as port number (16 bits)).
 	transform_ip := func(o interface{})(string) {
-		ip := make(net.IP, 4)
-		binary.BigEndian.PutUint32(ip, o.(uint32))
-		return fmt.Sprintf("%s", ip)
+		return fmt.Sprintf("%s", networkIP(o))
 	}
 
 	transform_ipport := func(o interface{})(string) {
+		if p, is6 := o.(ipv6NetworkPort); is6 {
+			return fmt.Sprintf("%s : %d", networkIP(p.ip), p.port)
+		}
 		ip := make(net.IP, 4)
 		binary.BigEndian.PutUint32(ip, uint32(o.(uint64) >> 32))
 		return fmt.Sprintf("%s : %d", ip, uint8(o.(uint64)))
 	}
 
 	transform_srcnet := func(o interface{})(string) {
-		ip := make(net.IP, 4)
-		binary.BigEndian.PutUint32(ip, o.(uint32))
-		return fmt.Sprintf("%s/%d", ip, srcprefix)
+		if _, is6 := o.(ipv6Network); is6 {
+			return fmt.Sprintf("%s/%d", networkIP(o), srcprefix6)
+		}
+		return fmt.Sprintf("%s/%d", networkIP(o), srcprefix)
 	}
 
 	transform_dstnet := func(o interface{})(string) {
-		ip := make(net.IP, 4)
-		binary.BigEndian.PutUint32(ip, o.(uint32))
-		return fmt.Sprintf("%s/%d", ip, dstprefix)
+		if _, is6 := o.(ipv6Network); is6 {
+			return fmt.Sprintf("%s/%d", networkIP(o), dstprefix6)
+		}
+		return fmt.Sprintf("%s/%d", networkIP(o), dstprefix)
 	}
 
 	transform_srcdstnet := func(o interface{})(string) {
+		if p, is6 := o.(ipv6NetworkPair); is6 {
+			return fmt.Sprintf("%s/%d <-> %s/%d", networkIP(p.src), srcprefix6, networkIP(p.dst), dstprefix6)
+		}
 		src := uint32(o.(uint64) >> 32)
 		dst := uint32(o.(uint64))
 		srcip := make(net.IP, 4)
@@ -571,47 +581,23 @@ func ProcessFinished() {
 	}
 
 	transform_tcp_session_state := func(o interface{})(string) {
-		name, exists := dict_tcp_session_state[o.(uint8)]
-		if !exists { return "UNKNOWN"
-		} else { return name }
+		return fortifields.StateName(6, o.(uint8))
 	}
 
 	transform_tcp_combined_session_state := func(o interface{})(string) {
 		left  := uint8(o.(uint16) >> 8)
 		right := uint8(o.(uint16))
-		lname, lexists := dict_tcp_session_state[left]
-		rname, rexists := dict_tcp_session_state[right]
-		var r string
-
-		if !lexists { r += "UNKNOWN"
-		} else { r += lname }
-		r += " / "
-		if !rexists { r += "UNKNOWN"
-		} else { r += rname }
-
-		return r
+		return fortifields.StateName(6, left) + " / " + fortifields.StateName(6, right)
 	}
 
 	transform_udp_combined_session_state := func(o interface{})(string) {
 		left  := uint8(o.(uint16) >> 8)
 		right := uint8(o.(uint16))
-		lname, lexists := dict_udp_session_state[left]
-		rname, rexists := dict_udp_session_state[right]
-		var r string
-
-		if !lexists { r += "UNKNOWN"
-		} else { r += lname }
-		r += " / "
-		if !rexists { r += "UNKNOWN"
-		} else { r += rname }
-
-		return r
+		return fortifields.StateName(17, left) + " / " + fortifields.StateName(17, right)
 	}
 
 	transform_udp_session_state := func(o interface{})(string) {
-		name, exists := dict_udp_session_state[o.(uint8)]
-		if !exists { return "UNKNOWN"
-		} else { return name }
+		return fortifields.StateName(17, o.(uint8))
 	}
 
 
@@ -1148,7 +1134,7 @@ func ProcessFinished() {
 	params["showOthers"] = true
 	params["showSummary"] = true
 	params["transform"] = func(s interface{})(string) {
-		if s.(uint32) == 0 { return "No source NAT"
+		if isEmptyNetwork(s) { return "No source NAT"
 		} else { return transform_ip(s) }
 	}
 	params["valueformat"] = "number"
@@ -1174,8 +1160,7 @@ func ProcessFinished() {
 		params["showOthers"] = true
 		params["showSummary"] = true
 		params["transform"] = func(s interface{})(string) {
-			port := s.(uint64)
-			if port == 0 { return "No source NAT"
+			if s == interface{}(uint64(0)) { return "No source NAT"
 			} else { return transform_ipport(s) }
 		}
 		params["valueformat"] = "number"
@@ -1294,29 +1279,78 @@ func createDirectory(basedir string) {
 }
 
 // Local functions
-func getNetwork(ip net.IP, mask uint32) uint32 {
-	ip  = ip.To4()
-	num := binary.BigEndian.Uint32(ip)
-	num &= mask
-	return num
+
+// ipv6Network holds IPv6 address (or network) in the form usable as counter key.
+// IPv4 addresses are kept as uint32 instead.
+type ipv6Network [16]byte
+
+// ipv6NetworkPair is the counter key for source and destination IPv6 networks.
+type ipv6NetworkPair struct {
+	src  ipv6Network
+	dst  ipv6Network
+}
+
+// ipv6NetworkPort is the counter key for IPv6 address and port.
+type ipv6NetworkPort struct {
+	ip   ipv6Network
+	port uint16
 }
 
-func initDictTCPState() {
-	dict_tcp_session_state = make(map[uint8]string)
-	dict_tcp_session_state[0] = "NONE"
-	dict_tcp_session_state[1] = "ESTABLISHED"
-	dict_tcp_session_state[2] = "SYN_SENT"
-	dict_tcp_session_state[3] = "SYN_RECV"
-	dict_tcp_session_state[4] = "FIN_WAIT"
-	dict_tcp_session_state[5] = "TIME_WAIT"
-	dict_tcp_session_state[6] = "CLOSE"
-	dict_tcp_session_state[7] = "CLOSE_WAIT"
-	dict_tcp_session_state[8] = "LAST_ACK"
-	dict_tcp_session_state[9] = "LISTEN"
+// getNetwork returns the counter key for the network the ip belongs to.
+// For IPv4 addresses it is uint32 masked by `mask`, for IPv6 addresses
+// it is ipv6Network masked by `prefix6` bits.
+func getNetwork(ip net.IP, mask uint32, prefix6 uint32) interface{} {
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		if ip4 == nil { return uint32(0) }
+		num := binary.BigEndian.Uint32(ip4)
+		num &= mask
+		return num
+	}
+
+	var n ipv6Network
+	copy(n[:], ip.Mask(net.CIDRMask(int(prefix6), 128)))
+	return n
+}
+
+// getNetworkPair combines source and destination network keys returned by getNetwork.
+// If the families differ (which should not happen), only IPv6 key is created.
+func getNetworkPair(src interface{}, dst interface{}) interface{} {
+	src4, ok_src := src.(uint32)
+	dst4, ok_dst := dst.(uint32)
+	if ok_src && ok_dst {
+		return uint64(src4) << 32 | uint64(dst4)
+	}
+
+	var pair ipv6NetworkPair
+	pair.src, _ = src.(ipv6Network)
+	pair.dst, _ = dst.(ipv6Network)
+	return pair
 }
 
-func initDictUDPState() {
-	dict_udp_session_state = make(map[uint8]string)
-	dict_udp_session_state[0] = "UNSEEN"
-	dict_udp_session_state[1] = "SEEN"
+// getNetworkPort combines network key returned by getNetwork with the port number.
+func getNetworkPort(ip interface{}, port uint16) interface{} {
+	if ip4, ok := ip.(uint32); ok {
+		return uint64(ip4) << 32 | uint64(port)
+	}
+	return ipv6NetworkPort{ ip: ip.(ipv6Network), port: port }
+}
+
+// isEmptyNetwork returns true for unspecified address ("0.0.0.0" or "::").
+func isEmptyNetwork(key interface{}) bool {
+	if key4, ok := key.(uint32); ok { return key4 == 0 }
+	if key6, ok := key.(ipv6Network); ok { return key6 == ipv6Network{} }
+	return false
+}
+
+// networkIP converts the key returned by getNetwork back to IP address.
+func networkIP(key interface{}) net.IP {
+	if key6, ok := key.(ipv6Network); ok {
+		ip := make(net.IP, 16)
+		copy(ip, key6[:])
+		return ip
+	}
+
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, key.(uint32))
+	return ip
 }
@@ -0,0 +1,218 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"fmt"
+	"path"
+	"sort"
+	"bufio"
+	"strings"
+)
+
+// Profiles are the named filters and output formats loaded from the user file,
+// they are referenced as "@name" in the filter and the output format
+type Profiles struct {
+	filename  string
+	filters   map[string]string
+	outputs   map[string]string
+}
+
+const (
+	PROFILE_FILTER = "filters"
+	PROFILE_OUTPUT = "outputs"
+)
+
+// profiles_file returns the file with profiles, which is in "FOSET_PROFILES" env
+// or $HOME/.foset/profiles if it is empty
+func profiles_file() (string, bool) {
+	env := os.Getenv("FOSET_PROFILES")
+	if len(env) > 0 { return env, true }
+	return path.Join(os.Getenv("HOME"), ".foset", "profiles"), false
+}
+
+// load_profiles reads the profiles file, the default file does not need to exist
+func load_profiles() (*Profiles, error) {
+	filename, explicit := profiles_file()
+	p := &Profiles{
+		filename : filename,
+		filters  : make(map[string]string),
+		outputs  : make(map[string]string),
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit { return p, nil }
+		return nil, err
+	}
+	defer f.Close()
+
+	var section map[string]string
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || text[0] == '#' { continue }
+
+		if text[0] == '[' && text[len(text)-1] == ']' {
+			name := strings.TrimSpace(text[1:len(text)-1])
+			if name == PROFILE_FILTER {
+				section = p.filters
+			} else if name == PROFILE_OUTPUT {
+				section = p.outputs
+			} else {
+				return nil, fmt.Errorf("%s: line %d: unknown section \"%s\", expected \"[%s]\" or \"[%s]\"", filename, line, name, PROFILE_FILTER, PROFILE_OUTPUT)
+			}
+			continue
+		}
+
+		if section == nil {
+			return nil, fmt.Errorf("%s: line %d: profile outside of \"[%s]\" or \"[%s]\" section", filename, line, PROFILE_FILTER, PROFILE_OUTPUT)
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		name  := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(name) == 0 {
+			return nil, fmt.Errorf("%s: line %d: expected \"name = value\"", filename, line)
+		}
+		for _, c := range name {
+			if !is_profile_char(c) {
+				return nil, fmt.Errorf("%s: line %d: invalid profile name \"%s\"", filename, line, name)
+			}
+		}
+		if _, exists := section[name]; exists {
+			return nil, fmt.Errorf("%s: line %d: profile \"%s\" is defined twice", filename, line, name)
+		}
+		section[name] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil { return nil, err }
+
+	log.Debugf("Loaded %d filter and %d output profiles from \"%s\"", len(p.filters), len(p.outputs), filename)
+	return p, nil
+}
+
+func is_profile_char(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.'
+}
+
+// ExpandFilter replaces the "@name" references in the filter with the filter profiles
+// in parentheses. Text in quotes is not expanded and the word after "in" operator
+// is left as it is when there is no such profile, because it can be IP set file.
+func (p *Profiles) ExpandFilter(filter string) (string, error) {
+	return p.expand(filter, PROFILE_FILTER, p.filters, []string{})
+}
+
+// ExpandOutput replaces the "@name" references in the output format with the output profiles,
+// "@" preceded by a letter or number (like in an email address) is not a reference.
+func (p *Profiles) ExpandOutput(output string) (string, error) {
+	return p.expand(output, PROFILE_OUTPUT, p.outputs, []string{})
+}
+
+func (p *Profiles) expand(text string, kind string, profiles map[string]string, stack []string) (string, error) {
+	var ret strings.Builder
+	var quote byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		// quoted text in filter is taken as it is
+		if kind == PROFILE_FILTER {
+			if quote != 0 && c == '\\' && i+1 < len(text) {
+				ret.WriteByte(c)
+				ret.WriteByte(text[i+1])
+				i += 1
+				continue
+			} else if quote != 0 && c == quote {
+				quote = 0
+			} else if quote == 0 && (c == '"' || c == '\'') {
+				quote = c
+			}
+		}
+
+		if c != '@' || quote != 0 || (i > 0 && is_profile_char(rune(text[i-1]))) {
+			ret.WriteByte(c)
+			continue
+		}
+
+		end := i+1
+		for end < len(text) && is_profile_char(rune(text[end])) { end += 1 }
+		name := text[i+1:end]
+		if len(name) == 0 {
+			ret.WriteByte(c)
+			continue
+		}
+
+		// after "in" operator it is always the IP set file (like "dhost in @servers")
+		if kind == PROFILE_FILTER && after_in_operator(text[:i]) {
+			ret.WriteString(text[i:end])
+			i = end-1
+			continue
+		}
+
+		value, exists := profiles[name]
+		if !exists {
+			if kind == PROFILE_FILTER {
+				return "", fmt.Errorf("unknown filter profile \"@%s\"", name)
+			}
+			ret.WriteString(text[i:end])
+			i = end-1
+			continue
+		}
+
+		for _, s := range stack {
+			if s == name {
+				return "", fmt.Errorf("profile \"@%s\" refers to itself (%s -> @%s)", name, "@"+strings.Join(stack, " -> @"), name)
+			}
+		}
+
+		expanded, err := p.expand(value, kind, profiles, append(stack, name))
+		if err != nil { return "", err }
+
+		if kind == PROFILE_FILTER {
+			ret.WriteString("(" + expanded + ")")
+		} else {
+			ret.WriteString(expanded)
+		}
+		i = end-1
+	}
+
+	return ret.String(), nil
+}
+
+// after_in_operator returns true if the last word of the text is "in" operator (also negated)
+func after_in_operator(text string) bool {
+	words := strings.Fields(text)
+	if len(words) == 0 { return false }
+	last := strings.TrimPrefix(words[len(words)-1], "!")
+	return last == "in"
+}
+
+// List returns the text with all profiles sorted by name
+func (p *Profiles) List() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("Profiles file: %s\n", p.filename))
+
+	for _, section := range []string{ PROFILE_FILTER, PROFILE_OUTPUT } {
+		profiles := p.filters
+		if section == PROFILE_OUTPUT { profiles = p.outputs }
+
+		names := make([]string, 0, len(profiles))
+		width := 0
+		for name, _ := range profiles {
+			names = append(names, name)
+			if len(name) > width { width = len(name) }
+		}
+		sort.Strings(names)
+
+		s.WriteString(fmt.Sprintf("\n[%s]\n", section))
+		if len(names) == 0 { s.WriteString("# none\n") }
+		for _, name := range names {
+			s.WriteString(fmt.Sprintf("%-*s = %s\n", width, name, profiles[name]))
+		}
+	}
+
+	return s.String()
+}
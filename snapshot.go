@@ -0,0 +1,128 @@
+// Copyright 2020 Ondrej Holecek <ondrej@holecek.eu>. All rights reserved. Use of this source code
+// is governed by the CC BY-ND 4.0 license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+	"time"
+	"foset/fortisession"
+)
+
+// command echo starting the new session dump, the commands can be abbreviated
+var snapshot_command   = regexp.MustCompile("(?i)\\b(dia\\w*\\s+sys\\w*\\s+(ses\\w*|npu-ses\\w*|mcast-ses\\w*)|get\\s+sys\\w*\\s+ses\\w*)\\s+li\\w*\\s*$")
+// trailer of the session dump ("total session 12", just "total 12" for npu-session list)
+var snapshot_total     = regexp.MustCompile("^total\\s+(session\\w*\\s+)?[0-9]+\\s*$")
+// timestamps that can precede the session dump
+// - "2020-05-01 10:00:00" (also with "." or "/" and "T"), like the PuTTY log header or `date "+%F %T"`
+// - "Fri May  1 10:00:00 CEST 2020" like `date` or "System time:" in `get system status`
+var snapshot_time_iso  = regexp.MustCompile("([0-9]{4})[-./]([0-9]{2})[-./]([0-9]{2})[ T]([0-9]{2}):([0-9]{2}):([0-9]{2})")
+var snapshot_time_date = regexp.MustCompile("([A-Z][a-z]{2})\\s+([A-Z][a-z]{2})\\s+([0-9]{1,2})\\s+([0-9]{2}:[0-9]{2}:[0-9]{2})\\s+(?:[A-Z]+\\s+)?([0-9]{4})")
+
+// SnapshotSplitter wraps the scanner split function and tracks in which session dump
+// (snapshot) the returned sessions are. The text between sessions is inspected for the
+// command echo, the "total" trailer and timestamps.
+type SnapshotSplitter struct {
+	split     bufio.SplitFunc
+	current   *fortisession.Snapshot
+	closed    bool       // next session starts new snapshot
+	pending   time.Time  // timestamp for the next snapshot
+}
+
+func InitSnapshotSplitter(split bufio.SplitFunc) (*SnapshotSplitter) {
+	return &SnapshotSplitter {
+		split : split,
+	}
+}
+
+// Current returns the snapshot of the last session returned by Split.
+func (ss *SnapshotSplitter) Current() (*fortisession.Snapshot) {
+	return ss.current
+}
+
+func (ss *SnapshotSplitter) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// skipped text between sessions is inspected and returned together with the next
+	// session, because bufio.Scanner stops when nothing is returned after the EOF
+	skipped := 0
+	for {
+		advance, token, err = ss.split(data[skipped:], atEOF)
+		if err != nil || token != nil || advance == 0 { break }
+		ss.inspect(data[skipped:skipped+advance])
+		skipped += advance
+	}
+	advance += skipped
+	if err != nil || token == nil { return }
+
+	// the last session in the dump also contains the text up to the next session start,
+	// the session itself ends with the first empty line
+	var rest []byte
+	end := snapshot_end(token)
+	if end != -1 {
+		// keep the new line at the end of the session
+		rest  = token[end+1:]
+		token = token[:end+1]
+	}
+
+	// is it the first session of the new snapshot?
+	if ss.current == nil || ss.closed {
+		var index uint32 = 1
+		if ss.current != nil { index = ss.current.Index + 1 }
+		ss.current  = &fortisession.Snapshot { Index: index, Time: ss.pending }
+		ss.pending  = time.Time{}
+		log.Debugf("Snapshot %d started (time \"%s\")", index, format_snapshot_time(ss.current.Time))
+	}
+
+	ss.closed = false
+
+	if rest != nil { ss.inspect(rest) }
+	return
+}
+
+// inspect checks the text outside of sessions line by line
+func (ss *SnapshotSplitter) inspect(text []byte) {
+	for _, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 { continue }
+
+		if snapshot_command.MatchString(line) || snapshot_total.MatchString(line) {
+			ss.closed = true
+		}
+
+		t := parse_snapshot_time(line)
+		if !t.IsZero() { ss.pending = t }
+	}
+}
+
+// snapshot_end returns the position of the first empty line or "total" trailer
+// in the session text or -1 if there is none
+func snapshot_end(token []byte) int {
+	end := -1
+	for _, e := range [][]byte{ []byte("\n\n"), []byte("\n\r\n"), []byte("\ntotal ") } {
+		i := bytes.Index(token[1:], e)
+		if i != -1 && (end == -1 || i+1 < end) { end = i+1 }
+	}
+	return end
+}
+
+// parse_snapshot_time returns the timestamp found on the line or zero time
+func parse_snapshot_time(line string) (time.Time) {
+	if m := snapshot_time_iso.FindStringSubmatch(line); m != nil {
+		t, err := time.Parse("2006-01-02 15:04:05", m[1]+"-"+m[2]+"-"+m[3]+" "+m[4]+":"+m[5]+":"+m[6])
+		if err == nil { return t }
+	}
+
+	if m := snapshot_time_date.FindStringSubmatch(line); m != nil {
+		t, err := time.Parse("Mon Jan 2 15:04:05 2006", m[1]+" "+m[2]+" "+m[3]+" "+m[4]+" "+m[5])
+		if err == nil { return t }
+	}
+
+	return time.Time{}
+}
+
+func format_snapshot_time(t time.Time) string {
+	if t.IsZero() { return "-" }
+	return t.Format("2006-01-02 15:04:05")
+}